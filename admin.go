@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-bot/ai"
+	"ai-bot/config"
+	"ai-bot/ratelimit"
+	"ai-bot/store"
+	"ai-bot/usage"
+)
+
+const (
+	// adminCookie имя cookie с сессией администратора
+	adminCookie = "ai_bot_admin"
+	// adminSessionTTL время жизни входа в админку
+	adminSessionTTL = 12 * time.Hour
+	// adminRecentConversations число разговоров на главной странице админки
+	adminRecentConversations = 20
+	// adminRecentDays число дней в таблице расхода
+	adminRecentDays = 14
+
+	// pbkdf2Iterations число итераций PBKDF2 для новых хэшей паролей
+	pbkdf2Iterations = 600000
+)
+
+// hashPassword возвращает хэш пароля для ADMIN_PASSWORD_HASH в формате
+// pbkdf2-sha256:<итерации>:<соль>:<хэш>. Разделитель не "$": godotenv
+// подставляет переменные окружения вместо $...
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, pbkdf2Iterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256:%d:%s:%s", pbkdf2Iterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// checkPassword сравнивает пароль с хэшем из hashPassword
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, ":")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// validPasswordHash проверяет формат ADMIN_PASSWORD_HASH
+func validPasswordHash(hash string) bool {
+	parts := strings.Split(hash, ":")
+	return len(parts) == 4 && parts[0] == "pbkdf2-sha256"
+}
+
+// adminSession вход администратора через форму
+type adminSession struct {
+	expires time.Time
+	csrf    string // Токен форм, защищает от отправки форм с чужих сайтов
+}
+
+// adminHandler страницы /admin: статус, расход, последние разговоры и
+// основные настройки. Доступ по паролю (форма входа и cookie) или по
+// токену в заголовке Authorization: Bearer.
+type adminHandler struct {
+	passwordHash   string
+	token          string
+	trustedProxies []*net.IPNet
+	logins         *ratelimit.Limiter // Попытки входа с одного IP
+
+	manager  *configManager
+	tracker  *usage.Tracker
+	sessions store.SessionStore
+	started  time.Time
+
+	mu     sync.Mutex
+	logged map[string]*adminSession
+}
+
+// newAdminHandler возвращает nil, если не задан ни пароль, ни токен
+func newAdminHandler(cfg *config.Config, manager *configManager, tracker *usage.Tracker, sessions store.SessionStore) (*adminHandler, error) {
+	if cfg.AdminPasswordHash == "" && cfg.AdminToken == "" {
+		return nil, nil
+	}
+	if cfg.AdminPasswordHash != "" && !validPasswordHash(cfg.AdminPasswordHash) {
+		return nil, fmt.Errorf("ADMIN_PASSWORD_HASH has unknown format, generate it with --hash-password")
+	}
+	trusted, err := ratelimit.ParseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &adminHandler{
+		passwordHash:   cfg.AdminPasswordHash,
+		token:          cfg.AdminToken,
+		trustedProxies: trusted,
+		logins:         ratelimit.NewLimiter(5, 5),
+		manager:        manager,
+		tracker:        tracker,
+		sessions:       sessions,
+		started:        time.Now(),
+		logged:         make(map[string]*adminSession),
+	}, nil
+}
+
+// Wrap пропускает к обработчику только администратора. Формы, отправленные
+// с сессией из cookie, должны содержать токен csrf этой сессии.
+func (a *adminHandler) Wrap(next func(w http.ResponseWriter, r *http.Request, session *adminSession)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Frame-Options", "DENY")
+
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if !a.validToken(bearer) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next(w, r, nil)
+			return
+		}
+
+		session := a.session(r)
+		if session == nil {
+			if r.Method == http.MethodGet && r.URL.Path == "/admin" {
+				a.render(w, "login", map[string]interface{}{"Error": r.URL.Query().Get("error")})
+				return
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost && subtle.ConstantTimeCompare([]byte(r.FormValue("csrf")), []byte(session.csrf)) != 1 {
+			http.Error(w, "Invalid form token", http.StatusForbidden)
+			return
+		}
+		next(w, r, session)
+	}
+}
+
+func (a *adminHandler) validToken(token string) bool {
+	return a.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// session возвращает действующую сессию из cookie или nil
+func (a *adminHandler) session(r *http.Request) *adminSession {
+	cookie, err := r.Cookie(adminCookie)
+	if err != nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	session, ok := a.logged[cookie.Value]
+	if !ok || time.Now().After(session.expires) {
+		delete(a.logged, cookie.Value)
+		return nil
+	}
+	return session
+}
+
+// Login проверяет пароль или токен из формы входа и открывает сессию
+func (a *adminHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ok, wait := a.logins.Allow(ratelimit.ClientIP(r, a.trustedProxies)); !ok {
+		tooManyRequests(w, wait)
+		return
+	}
+
+	secret := r.FormValue("password")
+	if !(a.passwordHash != "" && checkPassword(a.passwordHash, secret)) && !a.validToken(secret) {
+		log.Printf("Админка: неудачная попытка входа с %s", ratelimit.ClientIP(r, a.trustedProxies))
+		http.Redirect(w, r, "/admin?error=1", http.StatusSeeOther)
+		return
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	a.mu.Lock()
+	now := time.Now()
+	for key, session := range a.logged {
+		if now.After(session.expires) {
+			delete(a.logged, key)
+		}
+	}
+	a.logged[id] = &adminSession{expires: now.Add(adminSessionTTL), csrf: csrf}
+	a.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     adminCookie,
+		Value:    id,
+		Path:     "/admin",
+		MaxAge:   int(adminSessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// Logout закрывает сессию
+func (a *adminHandler) Logout(w http.ResponseWriter, r *http.Request, session *adminSession) {
+	if cookie, err := r.Cookie(adminCookie); err == nil {
+		a.mu.Lock()
+		delete(a.logged, cookie.Value)
+		a.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: adminCookie, Path: "/admin", MaxAge: -1})
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// adminStats данные для обновления статуса на странице без перезагрузки
+type adminStats struct {
+	Configured bool              `json:"configured"`
+	Provider   string            `json:"provider"`
+	Uptime     string            `json:"uptime"`
+	Budget     string            `json:"budget"`
+	Today      usage.Totals      `json:"today"`
+	Month      usage.Totals      `json:"month"`
+	Days       []usage.DayTotals `json:"days"`
+
+	Providers []ai.HealthStatus `json:"providers"` // Проверки провайдеров вместе с последними ошибками
+}
+
+func (a *adminHandler) stats() adminStats {
+	state := a.manager.Current()
+	days := a.tracker.Days()
+	if len(days) > adminRecentDays {
+		days = days[:adminRecentDays]
+	}
+	return adminStats{
+		Configured: state.client.IsConfigured(),
+		Provider:   state.client.GetProvider(),
+		Uptime:     time.Since(a.started).Round(time.Second).String(),
+		Budget:     a.tracker.Check(state.budget.Budget).String(),
+		Today:      a.tracker.Today(),
+		Month:      a.tracker.Month(time.Now()),
+		Days:       days,
+		Providers:  state.health.Status(),
+	}
+}
+
+// Stats отдает статус и расход в JSON
+func (a *adminHandler) Stats(w http.ResponseWriter, r *http.Request, session *adminSession) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.stats())
+}
+
+// Dashboard главная страница админки
+func (a *adminHandler) Dashboard(w http.ResponseWriter, r *http.Request, session *adminSession) {
+	if r.URL.Path != "/admin" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Действующие настройки; сохраненные в .env, но не примененные из-за
+	// ошибки, не показываются
+	state := a.manager.Current()
+
+	conversations, err := a.sessions.Recent(r.Context(), adminRecentConversations)
+	if err != nil {
+		log.Printf("Админка: %v", err)
+	}
+
+	// Список моделей для подсказки в форме берется из кэша клиента;
+	// ошибка не мешает странице
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	models, err := state.client.Models(ctx)
+	cancel()
+	var modelsError string
+	if err != nil && len(models) == 0 {
+		modelsError = err.Error()
+	}
+
+	primary := primaryProvider(state.client, state.cfg)
+	data := map[string]interface{}{
+		"Stats":         a.stats(),
+		"Conversations": conversations,
+		"Models":        models,
+		"ModelsError":   modelsError,
+		"Config":        state.cfg,
+		"Primary":       primary,
+		"Saved":         r.URL.Query().Get("saved") != "",
+		"Error":         r.URL.Query().Get("error"),
+	}
+	if session != nil {
+		data["CSRF"] = session.csrf
+	}
+	a.render(w, "dashboard", data)
+}
+
+// primaryProvider возвращает настройки основного провайдера клиента
+func primaryProvider(client *ai.Client, cfg *config.Config) *config.ProviderConfig {
+	if providers := client.Providers(); len(providers) > 0 {
+		return cfg.Provider(providers[0].Name())
+	}
+	return nil
+}
+
+// Settings сохраняет системный промпт, модель, температуру и лимит токенов в .env
+func (a *adminHandler) Settings(w http.ResponseWriter, r *http.Request, session *adminSession) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Config error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	fail := func(message string) {
+		http.Redirect(w, r, "/admin?error="+url.QueryEscape(message), http.StatusSeeOther)
+	}
+
+	prompt := strings.TrimSpace(strings.ReplaceAll(r.FormValue("system_prompt"), "\r\n", "\n"))
+	if prompt == "" {
+		fail("Системный промпт не может быть пустым")
+		return
+	}
+	temperature, err := strconv.ParseFloat(r.FormValue("temperature"), 64)
+	if err != nil || temperature < 0 || temperature > 2 {
+		fail("Температура должна быть числом от 0 до 2")
+		return
+	}
+	maxTokens, err := strconv.Atoi(r.FormValue("max_tokens"))
+	if err != nil || maxTokens <= 0 {
+		fail("Лимит токенов должен быть положительным числом")
+		return
+	}
+	model := strings.TrimSpace(r.FormValue("model"))
+
+	cfg.SystemPrompt = prompt
+	cfg.Temperature = temperature
+	cfg.MaxTokens = maxTokens
+	if primary := primaryProvider(a.manager.Current().client, cfg); primary != nil && model != "" {
+		primary.Model = model
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fail(err.Error())
+		return
+	}
+
+	if err := config.Save(cfg); err != nil {
+		log.Printf("Админка: ошибка сохранения настроек: %v", err)
+		fail("Ошибка сохранения: " + err.Error())
+		return
+	}
+	log.Printf("Админка: настройки сохранены (модель %s, температура %.2f, лимит токенов %d)", model, temperature, maxTokens)
+
+	// Применяем сразу, не дожидаясь проверки файла
+	if err := a.manager.Reload("админка"); err != nil {
+		fail("Настройки сохранены, но не применены: " + err.Error())
+		return
+	}
+	http.Redirect(w, r, "/admin?saved=1", http.StatusSeeOther)
+}
+
+func (a *adminHandler) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := adminTemplates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("Админка: ошибка шаблона: %v", err)
+	}
+}
+
+// randomToken возвращает случайную строку для сессий и токенов форм
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+var adminTemplates = template.Must(template.New("admin").Funcs(template.FuncMap{
+	"cost": func(value float64) string {
+		return fmt.Sprintf("$%.4f", value)
+	},
+	"time": func(t time.Time) string {
+		return t.Format("02.01 15:04")
+	},
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html lang="ru">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>AI Bot - Администрирование</title>
+    <style>
+        * { margin: 0; padding: 0; box-sizing: border-box; }
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: #f4f5fb; color: #333; }
+        header { background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); color: white; padding: 20px 30px; display: flex; justify-content: space-between; align-items: center; }
+        header h1 { font-size: 22px; }
+        main { max-width: 1100px; margin: 0 auto; padding: 30px 20px; }
+        section { background: white; border-radius: 10px; padding: 20px 25px; margin-bottom: 25px; box-shadow: 0 2px 10px rgba(0,0,0,0.05); }
+        h2 { font-size: 18px; margin-bottom: 15px; color: #4a4a8a; }
+        table { width: 100%; border-collapse: collapse; font-size: 14px; }
+        th, td { text-align: left; padding: 8px 10px; border-bottom: 1px solid #eee; }
+        th { color: #666; font-weight: 600; }
+        .grid { display: grid; grid-template-columns: repeat(auto-fit, minmax(200px, 1fr)); gap: 15px; }
+        .card { background: #f8f9fd; border-radius: 8px; padding: 15px; }
+        .card b { display: block; font-size: 20px; margin-top: 5px; }
+        label { display: block; font-weight: 600; margin: 15px 0 5px; }
+        input, textarea { width: 100%; padding: 10px; border: 1px solid #dde; border-radius: 6px; font: inherit; }
+        textarea { min-height: 120px; }
+        button { background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); color: white; border: none; border-radius: 6px; padding: 10px 20px; font-size: 15px; cursor: pointer; margin-top: 15px; }
+        header button { background: rgba(255,255,255,0.2); margin: 0; }
+        .note { color: #777; font-size: 13px; margin-top: 10px; }
+        .ok { background: #e6f7ec; color: #23784a; padding: 10px 15px; border-radius: 6px; margin-bottom: 20px; }
+        .error { background: #fdecea; color: #a12a1f; padding: 10px 15px; border-radius: 6px; margin-bottom: 20px; }
+        .login { max-width: 360px; margin: 100px auto; }
+    </style>
+</head>
+<body>
+{{end}}
+
+{{define "login"}}{{template "head"}}
+<section class="login">
+    <h2>🤖 AI Bot - вход</h2>
+    {{if .Error}}<div class="error">Неверный пароль</div>{{end}}
+    <form method="post" action="/admin/login">
+        <label for="password">Пароль или токен</label>
+        <input type="password" id="password" name="password" autofocus>
+        <button type="submit">Войти</button>
+    </form>
+</section>
+</body>
+</html>
+{{end}}
+
+{{define "dashboard"}}{{template "head"}}
+<header>
+    <h1>🤖 AI Bot - администрирование</h1>
+    {{if .CSRF}}<form method="post" action="/admin/logout"><input type="hidden" name="csrf" value="{{.CSRF}}"><button type="submit">Выйти</button></form>{{end}}
+</header>
+<main>
+    {{if .Saved}}<div class="ok">Настройки сохранены в .env</div>{{end}}
+    {{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+
+    <section>
+        <h2>Статус</h2>
+        <div class="grid">
+            <div class="card">Провайдер<b id="provider">{{.Stats.Provider}}</b></div>
+            <div class="card">Работает<b id="uptime">{{.Stats.Uptime}}</b></div>
+            <div class="card">Бюджет<b id="budget">{{.Stats.Budget}}</b></div>
+            <div class="card">Сегодня<b id="today">{{.Stats.Today.Requests}} запр. / {{.Stats.Today.TotalTokens}} ток. / {{cost .Stats.Today.Cost}}</b></div>
+            <div class="card">За месяц<b id="month">{{.Stats.Month.Requests}} запр. / {{.Stats.Month.TotalTokens}} ток. / {{cost .Stats.Month.Cost}}</b></div>
+        </div>
+        <table style="margin-top: 15px">
+            <tr><th>Провайдер (порядок fallback)</th><th>Модель</th><th>Доступен</th><th>Circuit breaker</th><th>Последняя ошибка</th></tr>
+            {{range .Stats.Providers}}<tr><td>{{.Provider}}</td><td>{{.Model}}</td><td>{{if .Healthy}}да{{else if .LastCheck}}нет{{else}}не проверен{{end}}</td><td>{{.Circuit.State}}</td><td>{{if .LastErrorAt}}{{time .LastErrorAt}} {{.LastError}}{{end}}</td></tr>{{end}}
+        </table>
+    </section>
+
+    <section>
+        <h2>Расход по дням</h2>
+        <table>
+            <tr><th>День</th><th>Запросы</th><th>Токены запроса</th><th>Токены ответа</th><th>Стоимость</th></tr>
+            {{range .Stats.Days}}<tr><td>{{.Day}}</td><td>{{.Requests}}</td><td>{{.PromptTokens}}</td><td>{{.CompletionTokens}}</td><td>{{cost .Cost}}</td></tr>
+            {{else}}<tr><td colspan="5">Запросов пока не было</td></tr>{{end}}
+        </table>
+    </section>
+
+    <section>
+        <h2>Последние разговоры</h2>
+        <table>
+            <tr><th>Обновлен</th><th>Сайт</th><th>Сообщений</th><th>Токены</th><th>Начало</th></tr>
+            {{range .Conversations}}<tr><td>{{time .UpdatedAt}}</td><td>{{.Origin}}</td><td>{{.Messages}}</td><td>{{.PromptTokens}} / {{.CompletionTokens}}</td><td>{{.Preview}}</td></tr>
+            {{else}}<tr><td colspan="5">Разговоров нет</td></tr>{{end}}
+        </table>
+    </section>
+
+    <section>
+        <h2>Настройки</h2>
+        <form method="post" action="/admin/settings">
+            <input type="hidden" name="csrf" value="{{.CSRF}}">
+            <label for="system_prompt">Системный промпт</label>
+            <textarea id="system_prompt" name="system_prompt">{{.Config.SystemPrompt}}</textarea>
+            {{if .Primary}}
+            <label for="model">Модель ({{.Primary.Name}})</label>
+            <input id="model" name="model" value="{{.Primary.Model}}" list="models">
+            <datalist id="models">{{range .Models}}<option value="{{.ID}}">{{.Name}}</option>{{end}}</datalist>
+            {{if .ModelsError}}<p class="note">Список моделей недоступен: {{.ModelsError}}</p>{{end}}
+            {{end}}
+            <label for="temperature">Температура (0-2)</label>
+            <input id="temperature" name="temperature" type="number" step="0.05" min="0" max="2" value="{{.Config.Temperature}}">
+            <label for="max_tokens">Лимит токенов на ответ</label>
+            <input id="max_tokens" name="max_tokens" type="number" min="1" value="{{.Config.MaxTokens}}">
+            <button type="submit">Сохранить</button>
+            <p class="note">Настройки применяются сразу, начатые ответы завершаются со старыми настройками.</p>
+        </form>
+    </section>
+</main>
+<script>
+    // Обновляем статус без перезагрузки страницы
+    function formatTotals(t) {
+        return t.requests + ' запр. / ' + t.total_tokens + ' ток. / $' + t.cost.toFixed(4);
+    }
+    setInterval(async function() {
+        try {
+            var response = await fetch('/admin/stats', {credentials: 'same-origin'});
+            if (!response.ok) return;
+            var stats = await response.json();
+            document.getElementById('provider').textContent = stats.provider;
+            document.getElementById('uptime').textContent = stats.uptime;
+            document.getElementById('budget').textContent = stats.budget;
+            document.getElementById('today').textContent = formatTotals(stats.today);
+            document.getElementById('month').textContent = formatTotals(stats.month);
+        } catch (e) {
+            // Сервер недоступен: покажем данные при следующей попытке
+        }
+    }, 15000);
+</script>
+</body>
+</html>
+{{end}}
+`))
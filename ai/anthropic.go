@@ -0,0 +1,402 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// anthropicVersion версия Messages API, передаваемая в заголовке anthropic-version
+const anthropicVersion = "2023-06-01"
+
+// anthropicDefaultMaxTokens используется, если max_tokens не задан: для Anthropic он обязателен
+const anthropicDefaultMaxTokens = 1024
+
+// anthropicMaxTemperature верхняя граница temperature у Anthropic, OpenAI допускает до 2
+const anthropicMaxTemperature = 1
+
+func init() {
+	RegisterProvider("anthropic", ProviderType{
+		DefaultURL:  "https://api.anthropic.com/v1",
+		RequiresKey: true,
+		New:         newAnthropicProvider,
+	})
+}
+
+// anthropicProvider провайдер для Anthropic Messages API (/v1/messages)
+type anthropicProvider struct {
+	config     ProviderConfig
+	httpClient *http.Client
+}
+
+func newAnthropicProvider(cfg ProviderConfig, httpClient *http.Client) (Provider, error) {
+	return &anthropicProvider{
+		config:     cfg,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Name возвращает имя провайдера
+func (p *anthropicProvider) Name() string {
+	return p.config.Name
+}
+
+// Model возвращает модель по умолчанию
+func (p *anthropicProvider) Model() string {
+	return p.config.Model
+}
+
+// Chat отправляет запрос в Messages API
+func (p *anthropicProvider) Chat(ctx context.Context, chatReq ChatRequest) (*ChatResponse, error) {
+	req, err := p.newMessagesRequest(ctx, chatReq, false)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return p.readResponse(resp)
+}
+
+// ChatStream отправляет запрос с stream: true и разбирает события
+// content_block_delta из потока Server-Sent Events. Расход токенов
+// приходит в message_start (запрос) и message_delta (ответ).
+func (p *anthropicProvider) ChatStream(ctx context.Context, chatReq ChatRequest, onDelta func(string) error) (*ChatResponse, error) {
+	req, err := p.newMessagesRequest(ctx, chatReq, true)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Ошибки до начала потока приходят обычным JSON
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		result, err := p.readResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+		if err := onDelta(result.Content); err != nil {
+			return result, err
+		}
+		return result, nil
+	}
+
+	result := &ChatResponse{Model: chatReq.Model, RequestID: upstreamRequestID(resp.Header)}
+	if result.Model == "" {
+		result.Model = p.config.Model
+	}
+	var full strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Тип события дублируется в поле type данных, поэтому строки event: пропускаем
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			result.Content = full.String()
+			return result, fmt.Errorf("failed to unmarshal stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "error":
+			result.Content = full.String()
+			return result, p.apiError(0, nil, event.Error)
+		case "message_start":
+			if event.Message != nil {
+				if event.Message.Model != "" {
+					result.Model = event.Message.Model
+				}
+				result.Usage.PromptTokens = event.Message.Usage.InputTokens
+			}
+		case "message_delta":
+			if event.Delta.StopReason != "" {
+				result.FinishReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				result.Usage.CompletionTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			return p.finishStream(result, full.String())
+		case "content_block_delta":
+			if event.Delta.Type != "text_delta" || event.Delta.Text == "" {
+				continue
+			}
+			full.WriteString(event.Delta.Text)
+			if err := onDelta(event.Delta.Text); err != nil {
+				result.Content = full.String()
+				return result, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		result.Content = full.String()
+		return result, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	return p.finishStream(result, full.String())
+}
+
+// finishStream проверяет, что поток содержал текст, и дополняет ответ.
+// Пустой ответ с отказом модели возвращается без ошибки: клиент отличит
+// его от сбоя через filteredError и не станет повторять.
+func (p *anthropicProvider) finishStream(result *ChatResponse, content string) (*ChatResponse, error) {
+	if content == "" && !filteredReasons[result.FinishReason] {
+		return nil, fmt.Errorf("empty stream from %s", p.config.Name)
+	}
+	result.Content = content
+	result.Usage.TotalTokens = result.Usage.PromptTokens + result.Usage.CompletionTokens
+	return result, nil
+}
+
+// ListModels получает список моделей через /v1/models
+func (p *anthropicProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.config.BaseURL+"/models?limit=1000", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(req)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := readBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var modelsResp struct {
+		Data []struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"display_name"`
+		} `json:"data"`
+		Error *anthropicError `json:"error,omitempty"`
+	}
+	if resp.StatusCode >= 400 {
+		if json.Unmarshal(responseBody, &modelsResp) == nil && modelsResp.Error != nil {
+			return nil, p.apiError(resp.StatusCode, resp.Header, modelsResp.Error)
+		}
+		return nil, newAPIError(p.config.Name, resp.StatusCode, resp.Header, errorBody(responseBody))
+	}
+	if err := json.Unmarshal(responseBody, &modelsResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if modelsResp.Error != nil {
+		return nil, p.apiError(resp.StatusCode, resp.Header, modelsResp.Error)
+	}
+
+	models := make([]ModelInfo, len(modelsResp.Data))
+	for i, model := range modelsResp.Data {
+		models[i] = ModelInfo{
+			ID:   model.ID,
+			Name: model.DisplayName,
+		}
+	}
+	return models, nil
+}
+
+// CheckHealth проверяет доступность API и ключа запросом списка моделей
+func (p *anthropicProvider) CheckHealth(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.config.BaseURL+"/models?limit=1", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(req)
+	return checkEndpoint(p.httpClient, req)
+}
+
+// newMessagesRequest формирует HTTP запрос к /messages.
+// Системные сообщения выносятся в отдельное поле system, а подряд идущие
+// сообщения одной роли склеиваются: API требует чередования user/assistant.
+func (p *anthropicProvider) newMessagesRequest(ctx context.Context, chatReq ChatRequest, stream bool) (*http.Request, error) {
+	var system []string
+	var messages []anthropicMessage
+	for _, msg := range chatReq.Messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+			continue
+		}
+		if n := len(messages); n > 0 && messages[n-1].Role == msg.Role {
+			messages[n-1].Content += "\n\n" + msg.Content
+			continue
+		}
+		messages = append(messages, anthropicMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		})
+	}
+
+	model := chatReq.Model
+	if model == "" {
+		model = p.config.Model
+	}
+	maxTokens := chatReq.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = anthropicDefaultMaxTokens
+	}
+	temperature := chatReq.Temperature
+	if temperature > anthropicMaxTemperature {
+		temperature = anthropicMaxTemperature
+	}
+
+	request := anthropicRequest{
+		Model:       model,
+		System:      strings.Join(system, "\n\n"),
+		Messages:    messages,
+		MaxTokens:   maxTokens,
+		Temperature: temperature,
+		Stream:      stream,
+	}
+
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.config.BaseURL+"/messages", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	p.setHeaders(req)
+
+	return req, nil
+}
+
+// setHeaders добавляет заголовки авторизации и версии API
+func (p *anthropicProvider) setHeaders(req *http.Request) {
+	req.Header.Set("x-api-key", p.config.APIKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+	for key, value := range p.config.Headers {
+		req.Header.Set(key, value)
+	}
+}
+
+// readResponse разбирает ответ Messages API и собирает текст из блоков content
+func (p *anthropicProvider) readResponse(resp *http.Response) (*ChatResponse, error) {
+	responseBody, err := readBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var anthropicResp anthropicResponse
+	if err := json.Unmarshal(responseBody, &anthropicResp); err != nil {
+		if resp.StatusCode >= 400 {
+			return nil, newAPIError(p.config.Name, resp.StatusCode, resp.Header, errorBody(responseBody))
+		}
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if anthropicResp.Type == "error" || anthropicResp.Error != nil {
+		return nil, p.apiError(resp.StatusCode, resp.Header, anthropicResp.Error)
+	}
+
+	var content strings.Builder
+	for _, block := range anthropicResp.Content {
+		if block.Type == "text" {
+			content.WriteString(block.Text)
+		}
+	}
+
+	// Отказ модели приходит без текста, его разбирает filteredError
+	if content.Len() == 0 && !filteredReasons[anthropicResp.StopReason] {
+		return nil, fmt.Errorf("no response from %s", p.config.Name)
+	}
+
+	return &ChatResponse{
+		Content:      content.String(),
+		Model:        anthropicResp.Model,
+		FinishReason: anthropicResp.StopReason,
+		RequestID:    upstreamRequestID(resp.Header),
+		Usage: Usage{
+			PromptTokens:     anthropicResp.Usage.InputTokens,
+			CompletionTokens: anthropicResp.Usage.OutputTokens,
+			TotalTokens:      anthropicResp.Usage.InputTokens + anthropicResp.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+// apiError формирует ошибку из конверта ошибки Anthropic. Тип ошибки
+// (rate_limit_error, overloaded_error...) входит в сообщение, по нему
+// классифицируются ошибки внутри потока.
+func (p *anthropicProvider) apiError(status int, header http.Header, apiErr *anthropicError) error {
+	if apiErr == nil {
+		return newAPIError(p.config.Name, status, header, "unknown error")
+	}
+	return newAPIError(p.config.Name, status, header, apiErr.Type+": "+apiErr.Message)
+}
+
+// Внутренние структуры для Anthropic API
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float32            `json:"temperature"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type anthropicResponse struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Role    string `json:"role"`
+	Model   string `json:"model"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string          `json:"stop_reason"`
+	Usage      anthropicUsage  `json:"usage"`
+	Error      *anthropicError `json:"error,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicStreamEvent struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Message *anthropicResponse `json:"message,omitempty"` // message_start
+	Usage   *anthropicUsage    `json:"usage,omitempty"`   // message_delta
+	Error   *anthropicError    `json:"error,omitempty"`
+}
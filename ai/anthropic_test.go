@@ -0,0 +1,248 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestAnthropic возвращает провайдера Anthropic, запросы которого
+// обрабатывает handler
+func newTestAnthropic(t *testing.T, handler http.HandlerFunc) *anthropicProvider {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	provider, err := newAnthropicProvider(ProviderConfig{
+		Name:    "anthropic",
+		BaseURL: server.URL,
+		APIKey:  "test-key",
+		Model:   "claude-test",
+	}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.(*anthropicProvider)
+}
+
+func TestAnthropicRequest(t *testing.T) {
+	var got anthropicRequest
+	var header http.Header
+	provider := newTestAnthropic(t, func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Request-Id", "req_1")
+		fmt.Fprint(w, `{"type":"message","model":"claude-test-1","content":[{"type":"text","text":"При"},{"type":"text","text":"вет"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`)
+	})
+
+	response, err := provider.Chat(context.Background(), ChatRequest{Messages: []ChatMessage{
+		{Role: "system", Content: "Будь краток."},
+		{Role: "system", Content: "Отвечай по-русски."},
+		{Role: "user", Content: "Привет"},
+		{Role: "user", Content: "Как дела?"},
+		{Role: "assistant", Content: "Хорошо"},
+		{Role: "user", Content: "Отлично"},
+	}, Temperature: 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Get("x-api-key") != "test-key" || header.Get("anthropic-version") != anthropicVersion {
+		t.Errorf("headers = %v", header)
+	}
+	if got.System != "Будь краток.\n\nОтвечай по-русски." {
+		t.Errorf("system = %q", got.System)
+	}
+	wantMessages := []anthropicMessage{
+		{Role: "user", Content: "Привет\n\nКак дела?"},
+		{Role: "assistant", Content: "Хорошо"},
+		{Role: "user", Content: "Отлично"},
+	}
+	if fmt.Sprint(got.Messages) != fmt.Sprint(wantMessages) {
+		t.Errorf("messages = %v, want %v", got.Messages, wantMessages)
+	}
+	if got.Model != "claude-test" || got.MaxTokens != anthropicDefaultMaxTokens || got.Temperature != anthropicMaxTemperature || got.Stream {
+		t.Errorf("request = %+v", got)
+	}
+
+	want := ChatResponse{
+		Content:      "Привет",
+		Model:        "claude-test-1",
+		FinishReason: "end_turn",
+		RequestID:    "req_1",
+		Usage:        Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15},
+	}
+	if *response != want {
+		t.Errorf("response = %+v, want %+v", *response, want)
+	}
+}
+
+func TestAnthropicStream(t *testing.T) {
+	events := []string{
+		`event: message_start`,
+		`data: {"type":"message_start","message":{"model":"claude-test-1","usage":{"input_tokens":20,"output_tokens":1}}}`,
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`data: {"type":"ping"}`,
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"При"}}`,
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{}"}}`,
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"вет"}}`,
+		`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":7}}`,
+		`data: {"type":"message_stop"}`,
+	}
+	provider := newTestAnthropic(t, func(w http.ResponseWriter, r *http.Request) {
+		var req anthropicRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			t.Error("stream is not requested")
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprint(w, event+"\n\n")
+		}
+	})
+
+	var deltas []string
+	response, err := provider.ChatStream(context.Background(), ChatRequest{Messages: []ChatMessage{{Role: "user", Content: "Привет"}}}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deltas, "|") != "При|вет" {
+		t.Errorf("deltas = %q", deltas)
+	}
+	want := ChatResponse{
+		Content:      "Привет",
+		Model:        "claude-test-1",
+		FinishReason: "end_turn",
+		Usage:        Usage{PromptTokens: 20, CompletionTokens: 7, TotalTokens: 27},
+	}
+	if *response != want {
+		t.Errorf("response = %+v, want %+v", *response, want)
+	}
+}
+
+func TestAnthropicErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		kind        ErrorKind
+		is          error
+	}{
+		{"rate limit", http.StatusTooManyRequests, "application/json", `{"type":"error","error":{"type":"rate_limit_error","message":"Slow down"}}`, ErrorRateLimited, ErrRateLimited},
+		{"overloaded", 529, "application/json", `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, ErrorRetryable, nil},
+		{"auth", http.StatusUnauthorized, "application/json", `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, ErrorAuth, ErrAuth},
+		{"not found", http.StatusNotFound, "application/json", `{"type":"error","error":{"type":"not_found_error","message":"model: claude-x"}}`, ErrorNotFound, nil},
+		{"prompt too long", http.StatusBadRequest, "application/json", `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`, ErrorContextLength, ErrContextTooLong},
+		{"invalid request", http.StatusBadRequest, "application/json", `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: must be positive"}}`, ErrorInvalidRequest, nil},
+		{"html from proxy", http.StatusBadGateway, "text/html", `<html>Bad gateway</html>`, ErrorRetryable, nil},
+		{"error inside stream", http.StatusOK, "text/event-stream", "data: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n", ErrorRetryable, nil},
+		{"rate limit inside stream", http.StatusOK, "text/event-stream", "data: {\"type\":\"error\",\"error\":{\"type\":\"rate_limit_error\",\"message\":\"Slow down\"}}\n\n", ErrorRateLimited, ErrRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestAnthropic(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Request-Id", "req_err")
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			})
+			_, err := provider.ChatStream(context.Background(), ChatRequest{Messages: []ChatMessage{{Role: "user", Content: "Привет"}}}, func(string) error { return nil })
+
+			var providerErr *ProviderError
+			if !errors.As(err, &providerErr) {
+				t.Fatalf("error = %v, want *ProviderError", err)
+			}
+			if providerErr.Kind != tt.kind {
+				t.Errorf("kind = %v, want %v (%v)", providerErr.Kind, tt.kind, err)
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.is)
+			}
+			if tt.status != http.StatusOK && providerErr.RequestID != "req_err" {
+				t.Errorf("request id = %q", providerErr.RequestID)
+			}
+		})
+	}
+}
+
+func TestAnthropicRefusal(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream bool
+		body   string
+	}{
+		{"response", false, `{"type":"message","model":"claude-test","content":[],"stop_reason":"refusal","usage":{"input_tokens":12,"output_tokens":0}}`},
+		{"stream", true, "data: {\"type\":\"message_start\",\"message\":{\"model\":\"claude-test\",\"usage\":{\"input_tokens\":12}}}\n\n" +
+			"data: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"refusal\"},\"usage\":{\"output_tokens\":0}}\n\n" +
+			"data: {\"type\":\"message_stop\"}\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestAnthropic(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.stream {
+					w.Header().Set("Content-Type", "text/event-stream")
+				} else {
+					w.Header().Set("Content-Type", "application/json")
+				}
+				fmt.Fprint(w, tt.body)
+			})
+			fallback := &fakeProvider{name: "fallback"}
+			client := newTestClient(2, 0, provider, fallback)
+			messages := []ChatMessage{{Role: "user", Content: "Привет"}}
+
+			var err error
+			if tt.stream {
+				_, err = client.ChatStream(context.Background(), messages, func(string) error { return nil })
+			} else {
+				_, err = client.Chat(context.Background(), messages)
+			}
+			if !errors.Is(err, ErrContentFiltered) {
+				t.Errorf("error = %v, want ErrContentFiltered", err)
+			}
+			if calls, _ := fallback.stats(); calls != 0 {
+				t.Errorf("fallback called %d times after a refusal", calls)
+			}
+		})
+	}
+}
+
+func TestAnthropicListModelsErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		is          error
+		kind        ErrorKind
+	}{
+		{"auth", http.StatusUnauthorized, "application/json", `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, ErrAuth, ErrorAuth},
+		{"html from proxy", http.StatusServiceUnavailable, "text/html", `<html>Service unavailable</html>`, nil, ErrorRetryable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestAnthropic(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			})
+			_, err := provider.ListModels(context.Background())
+			if got := errorKind(err); got != tt.kind {
+				t.Errorf("kind = %v, want %v (%v)", got, tt.kind, err)
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.is)
+			}
+		})
+	}
+}
@@ -1,25 +1,24 @@
 package ai
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Config конфигурация AI клиента
 type Config struct {
-	OpenRouterAPIKey string
-	OpenRouterModel  string
-	OpenRouterURL    string
-	OpenAIAPIKey     string
-	OpenAIModel      string
-	MaxTokens        int
-	Temperature      float32
-	RequestTimeout   int
+	Providers      []ProviderConfig // Провайдеры в порядке fallback
+	MaxTokens      int
+	Temperature    float32
+	RequestTimeout int
+
+	Retries          int // Повторов временной ошибки у одного провайдера
+	BreakerThreshold int // Ошибок подряд до временного отключения провайдера, 0 - не отключать
+	BreakerCooldown  int // На сколько секунд отключается провайдер
 }
 
 // ChatMessage представляет сообщение в чате
@@ -32,169 +31,229 @@ type ChatMessage struct {
 type Client struct {
 	config     *Config
 	httpClient *http.Client
-}
+	providers  []Provider
+	usageHook  func(*ChatResult)
+	model      string // Модель основного провайдера вместо модели из конфигурации
 
-// NewClient создает новый AI клиент
-func NewClient(config *Config) *Client {
-	return &Client{
-		config: config,
-		httpClient: &http.Client{
-			Timeout: time.Duration(config.RequestTimeout) * time.Second,
-		},
-	}
-}
+	catalogs map[string]*modelCatalog   // По имени провайдера, общие для клиента и его копий
+	breakers map[string]*circuitBreaker // Общие для клиента и его копий
 
-// Chat отправляет запрос в чат с AI
-func (c *Client) Chat(ctx context.Context, messages []ChatMessage) (string, error) {
-	// Пробуем OpenRouter сначала
-	if c.config.OpenRouterAPIKey != "" {
-		response, err := c.chatOpenRouter(ctx, messages)
-		if err == nil {
-			return response, nil
-		}
-		// Логируем ошибку, но продолжаем с fallback
-		fmt.Printf("OpenRouter failed, falling back to OpenAI: %v\n", err)
-	}
-
-	// Fallback на OpenAI
-	if c.config.OpenAIAPIKey != "" {
-		return c.chatOpenAI(ctx, messages)
-	}
+	hedge      *Client       // Второй запрос гонки, см. WithHedge
+	hedgeDelay time.Duration // Пауза перед вторым запросом
+}
 
-	return "", fmt.Errorf("no AI provider configured")
+// modelCatalog кэш списка моделей одного провайдера
+type modelCatalog struct {
+	mu      sync.Mutex
+	list    []ModelInfo
+	models  map[string]ModelInfo // list по ID
+	fetched time.Time
+	err     error
+	loading chan struct{} // Закрывается по окончании текущей загрузки, nil - загрузки нет
 }
 
-// chatOpenRouter отправляет запрос в OpenRouter API
-func (c *Client) chatOpenRouter(ctx context.Context, messages []ChatMessage) (string, error) {
-	openAIMessages := make([]openAIMessage, len(messages))
-	for i, msg := range messages {
-		openAIMessages[i] = openAIMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		}
-	}
+const (
+	// catalogTTL как часто обновлять кэш списка моделей
+	catalogTTL = time.Hour
+	// catalogRetry пауза перед повторной попыткой после ошибки загрузки
+	catalogRetry = 5 * time.Minute
+	// catalogTimeout ограничение времени загрузки списка моделей
+	catalogTimeout = 10 * time.Second
+)
 
-	request := openAIRequest{
-		Model:       c.config.OpenRouterModel,
-		Messages:    openAIMessages,
-		MaxTokens:   c.config.MaxTokens,
-		Temperature: c.config.Temperature,
+// NewClient создает новый AI клиент с провайдерами из конфигурации
+func NewClient(config *Config) (*Client, error) {
+	httpClient := &http.Client{
+		Timeout: time.Duration(config.RequestTimeout) * time.Second,
 	}
 
-	requestBody, err := json.Marshal(request)
+	providers, err := buildProviders(config.Providers, httpClient)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, err
 	}
 
-	apiURL := c.config.OpenRouterURL + "/chat/completions"
-	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+	catalogs := make(map[string]*modelCatalog, len(providers))
+	breakers := make(map[string]*circuitBreaker, len(providers))
+	for _, provider := range providers {
+		catalogs[provider.Name()] = &modelCatalog{}
+		breakers[provider.Name()] = newCircuitBreaker(config.BreakerThreshold, time.Duration(config.BreakerCooldown)*time.Second)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.config.OpenRouterAPIKey)
-	req.Header.Set("HTTP-Referer", "https://ai-bot.local")
-	req.Header.Set("X-Title", "AI Bot")
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
+	return &Client{
+		config:     config,
+		httpClient: httpClient,
+		providers:  providers,
+		catalogs:   catalogs,
+		breakers:   breakers,
+	}, nil
+}
 
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+// WithProvider возвращает копию клиента, в которой провайдер name идет
+// первым, а остальные остаются запасными в прежнем порядке. Возвращает
+// false, если такого провайдера нет.
+func (c *Client) WithProvider(name string) (*Client, bool) {
+	for i, provider := range c.providers {
+		if provider.Name() != name {
+			continue
+		}
+		clone := *c
+		clone.model = ""
+		clone.providers = make([]Provider, 0, len(c.providers))
+		clone.providers = append(clone.providers, provider)
+		clone.providers = append(clone.providers, c.providers[:i]...)
+		clone.providers = append(clone.providers, c.providers[i+1:]...)
+		return &clone, true
 	}
+	return c, false
+}
 
-	var openAIResp openAIResponse
-	if err := json.Unmarshal(responseBody, &openAIResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
-	}
+// WithModel возвращает копию клиента, в которой основной провайдер
+// использует модель model. Провайдеры fallback остаются со своими моделями.
+func (c *Client) WithModel(model string) *Client {
+	clone := *c
+	clone.model = model
+	return &clone
+}
 
-	if openAIResp.Error != nil {
-		return "", fmt.Errorf("OpenRouter API error: %s", openAIResp.Error.Message)
+// Chat отправляет запрос в чат с AI, перебирая провайдеров по приоритету.
+// Временные ошибки повторяются у того же провайдера, ошибка в самом запросе
+// возвращается сразу, провайдеры с открытым circuit breaker пропускаются.
+// Класс ошибки определяется через errors.Is с ErrRateLimited, ErrTimeout и
+// другими ошибками клиента.
+func (c *Client) Chat(ctx context.Context, messages []ChatMessage) (*ChatResult, error) {
+	if c.hedge != nil {
+		return c.race(ctx, messages, nil)
 	}
-
-	if len(openAIResp.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenRouter")
+	start := time.Now()
+	var lastErr error
+	for i, provider := range c.providers {
+		if !c.breakers[provider.Name()].allow() {
+			if lastErr == nil {
+				lastErr = circuitOpenError(provider.Name())
+			}
+			continue
+		}
+		req := c.newRequest(i, messages)
+		response, _, err := c.attempt(ctx, provider, func() (*ChatResponse, bool, error) {
+			response, err := provider.Chat(ctx, req)
+			return response, false, err
+		})
+		if err == nil {
+			result := c.newResult(ctx, provider, messages, response, start)
+			if err := filteredError(provider, response); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+		lastErr = err
+		c.abandoned(ctx, provider, req, "", start)
+		if ctx.Err() != nil || errorKind(err).final() {
+			break
+		}
+		// Логируем ошибку, но продолжаем с fallback
+		if i < len(c.providers)-1 {
+			fmt.Printf("%s failed, falling back to %s: %v\n", provider.Name(), c.providers[i+1].Name(), err)
+		}
 	}
 
-	return openAIResp.Choices[0].Message.Content, nil
+	return nil, failure(ctx, lastErr)
 }
 
-// chatOpenAI отправляет запрос в OpenAI API
-func (c *Client) chatOpenAI(ctx context.Context, messages []ChatMessage) (string, error) {
-	openAIMessages := make([]openAIMessage, len(messages))
-	for i, msg := range messages {
-		openAIMessages[i] = openAIMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
+// ChatStream отправляет запрос в чат с AI в потоковом режиме.
+// Каждый полученный фрагмент ответа передается в onDelta, итоговый текст
+// возвращается целиком. Переход к следующему провайдеру возможен только
+// пока от текущего не пришло ни одного фрагмента.
+func (c *Client) ChatStream(ctx context.Context, messages []ChatMessage, onDelta func(string) error) (*ChatResult, error) {
+	if c.hedge != nil {
+		return c.race(ctx, messages, onDelta)
+	}
+	start := time.Now()
+	var lastErr error
+	for i, provider := range c.providers {
+		if !c.breakers[provider.Name()].allow() {
+			if lastErr == nil {
+				lastErr = circuitOpenError(provider.Name())
+			}
+			continue
+		}
+		req := c.newRequest(i, messages)
+		var received strings.Builder // Текст, который провайдер успел передать
+		response, streamed, err := c.attempt(ctx, provider, func() (*ChatResponse, bool, error) {
+			received.Reset()
+			streamed := false
+			response, err := provider.ChatStream(ctx, req, func(delta string) error {
+				streamed = true
+				received.WriteString(delta)
+				return onDelta(delta)
+			})
+			return response, streamed, err
+		})
+		if err == nil {
+			result := c.newResult(ctx, provider, messages, response, start)
+			if err := filteredError(provider, response); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+		c.abandoned(ctx, provider, req, received.String(), start)
+		if streamed || ctx.Err() != nil || errorKind(err).final() {
+			return nil, failure(ctx, err)
+		}
+		lastErr = err
+		// Логируем ошибку, но продолжаем с fallback
+		if i < len(c.providers)-1 {
+			fmt.Printf("%s stream failed, falling back to %s: %v\n", provider.Name(), c.providers[i+1].Name(), err)
 		}
 	}
 
-	request := openAIRequest{
-		Model:       c.config.OpenAIModel,
-		Messages:    openAIMessages,
+	return nil, failure(ctx, lastErr)
+}
+
+// newRequest формирует запрос к i-му провайдеру с общими параметрами клиента
+func (c *Client) newRequest(i int, messages []ChatMessage) ChatRequest {
+	req := ChatRequest{
+		Messages:    messages,
 		MaxTokens:   c.config.MaxTokens,
 		Temperature: c.config.Temperature,
 	}
-
-	requestBody, err := json.Marshal(request)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.config.OpenAIAPIKey)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
-
-	var openAIResp openAIResponse
-	if err := json.Unmarshal(responseBody, &openAIResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	if i == 0 {
+		req.Model = c.model
 	}
+	return req
+}
 
-	if openAIResp.Error != nil {
-		return "", fmt.Errorf("OpenAI API error: %s", openAIResp.Error.Message)
+// Model возвращает модель основного провайдера
+func (c *Client) Model() string {
+	if len(c.providers) == 0 {
+		return ""
 	}
-
-	if len(openAIResp.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenAI")
+	if c.model != "" {
+		return c.model
 	}
+	return c.providers[0].Model()
+}
 
-	return openAIResp.Choices[0].Message.Content, nil
+// MaxTokens возвращает лимит токенов на ответ
+func (c *Client) MaxTokens() int {
+	return c.config.MaxTokens
 }
 
 // IsConfigured проверяет, настроен ли AI клиент
 func (c *Client) IsConfigured() bool {
-	return c.config.OpenRouterAPIKey != "" || c.config.OpenAIAPIKey != ""
+	return len(c.providers) > 0
+}
+
+// Providers возвращает провайдеров в порядке приоритета
+func (c *Client) Providers() []Provider {
+	return c.providers
 }
 
-// GetProvider возвращает текущего провайдера AI
+// GetProvider возвращает текущего (основного) провайдера AI
 func (c *Client) GetProvider() string {
-	if c.config.OpenRouterAPIKey != "" {
-		return fmt.Sprintf("OpenRouter (%s)", c.config.OpenRouterModel)
+	if len(c.providers) == 0 {
+		return "Not configured"
 	}
-	if c.config.OpenAIAPIKey != "" {
-		return fmt.Sprintf("OpenAI (%s)", c.config.OpenAIModel)
-	}
-	return "Not configured"
+	return fmt.Sprintf("%s (%s)", c.providers[0].Name(), c.Model())
 }
 
 // ModelInfo информация о модели
@@ -214,75 +273,104 @@ type OpenRouterModelsResponse struct {
 	Data []ModelInfo `json:"data"`
 }
 
-// GetModels получает список доступных моделей от OpenRouter API
+// GetModels получает список доступных моделей у первого провайдера,
+// который поддерживает получение списка моделей
 func (c *Client) GetModels(ctx context.Context) ([]ModelInfo, error) {
-	if c.config.OpenRouterAPIKey == "" {
-		return nil, fmt.Errorf("OpenRouter API key not configured")
+	for _, provider := range c.providers {
+		if lister, ok := provider.(ModelLister); ok {
+			return lister.ListModels(ctx)
+		}
 	}
+	return nil, fmt.Errorf("no provider supports model listing")
+}
 
-	apiURL := c.config.OpenRouterURL + "/models"
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// LookupModel возвращает информацию о модели из кэшированного списка
+// моделей основного провайдера клиента
+func (c *Client) LookupModel(ctx context.Context, id string) (ModelInfo, bool) {
+	if len(c.providers) == 0 {
+		return ModelInfo{}, false
 	}
+	return c.lookupModel(ctx, c.providers[0], id)
+}
 
-	req.Header.Set("Authorization", "Bearer "+c.config.OpenRouterAPIKey)
-	req.Header.Set("HTTP-Referer", "https://ai-bot.local")
-	req.Header.Set("X-Title", "AI Bot")
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+// Models возвращает кэшированный список моделей основного провайдера
+// и ошибку его последней загрузки. В отличие от GetModels не обращается
+// к провайдеру чаще, чем раз в catalogTTL.
+func (c *Client) Models(ctx context.Context) ([]ModelInfo, error) {
+	if len(c.providers) == 0 {
+		return nil, fmt.Errorf("no provider configured")
 	}
-	defer resp.Body.Close()
-
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+	provider := c.providers[0]
+	catalog := c.catalogs[provider.Name()]
+	lister, ok := provider.(ModelLister)
+	if catalog == nil || !ok {
+		return nil, fmt.Errorf("%s does not support model listing", provider.Name())
 	}
+	list, _, err := catalog.get(ctx, lister)
+	return list, err
+}
 
-	var modelsResp OpenRouterModelsResponse
-	if err := json.Unmarshal(responseBody, &modelsResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+// lookupModel ищет модель в кэшированном списке моделей провайдера
+func (c *Client) lookupModel(ctx context.Context, provider Provider, id string) (ModelInfo, bool) {
+	catalog := c.catalogs[provider.Name()]
+	lister, ok := provider.(ModelLister)
+	if catalog == nil || !ok {
+		return ModelInfo{}, false
 	}
-
-	return modelsResp.Data, nil
+	_, models, _ := catalog.get(ctx, lister)
+	model, ok := models[id]
+	return model, ok
 }
 
-// Внутренние структуры для API
-type openAIRequest struct {
-	Model       string          `json:"model"`
-	Messages    []openAIMessage `json:"messages"`
-	MaxTokens   int             `json:"max_tokens"`
-	Temperature float32         `json:"temperature"`
-}
+// get возвращает список моделей, его же по ID и ошибку последней загрузки.
+// Список загружается лениво и обновляется раз в catalogTTL в фоне, пока
+// обновление идет, используется прежний список. Ждать загрузки приходится
+// только первому запросу; после ошибки повторная попытка делается не
+// раньше чем через catalogRetry.
+func (catalog *modelCatalog) get(ctx context.Context, lister ModelLister) ([]ModelInfo, map[string]ModelInfo, error) {
+	catalog.mu.Lock()
+	refresh := catalogTTL
+	if catalog.err != nil {
+		refresh = catalogRetry
+	}
+	if catalog.loading == nil && time.Since(catalog.fetched) > refresh {
+		catalog.loading = make(chan struct{})
+		go catalog.load(lister)
+	}
+	loading, first := catalog.loading, catalog.fetched.IsZero()
+	catalog.mu.Unlock()
+
+	if first {
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
 
-type openAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	return catalog.list, catalog.models, catalog.err
 }
 
-type openAIResponse struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Index   int `json:"index"`
-		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"message"`
-		FinishReason string `json:"finish_reason"`
-	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
-	Error *struct {
-		Message string `json:"message"`
-		Type    string `json:"type"`
-		Code    string `json:"code"`
-	} `json:"error,omitempty"`
+// load загружает список моделей без удержания блокировки. Загрузка не
+// зависит от контекста запроса, который ее начал: результат нужен всем.
+func (catalog *modelCatalog) load(lister ModelLister) {
+	ctx, cancel := context.WithTimeout(context.Background(), catalogTimeout)
+	models, err := lister.ListModels(ctx)
+	cancel()
+
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+	catalog.fetched = time.Now()
+	catalog.err = err
+	if err == nil {
+		catalog.list = models
+		catalog.models = make(map[string]ModelInfo, len(models))
+		for _, model := range models {
+			catalog.models[model.ID] = model
+		}
+	}
+	close(catalog.loading)
+	catalog.loading = nil
 }
@@ -0,0 +1,98 @@
+package ai
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLookupModelLoadsOnce(t *testing.T) {
+	provider := &listingProvider{
+		fakeProvider: &fakeProvider{name: "openrouter"},
+		models:       []ModelInfo{{ID: "model", ContextLength: 1000}},
+		hold:         make(chan struct{}),
+	}
+	client := newTestClient(0, 0, provider)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := client.LookupModel(context.Background(), "model"); !ok {
+				t.Error("LookupModel() did not wait for the first load")
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(provider.hold)
+	wg.Wait()
+
+	if lists := provider.listCount(); lists != 1 {
+		t.Errorf("ListModels called %d times, want 1", lists)
+	}
+}
+
+func TestLookupModelServesStaleWhileRefreshing(t *testing.T) {
+	provider := &listingProvider{
+		fakeProvider: &fakeProvider{name: "openrouter"},
+		models:       []ModelInfo{{ID: "model", ContextLength: 2000}},
+		hold:         make(chan struct{}),
+	}
+	client := newTestClient(0, 0, provider)
+	catalog := client.catalogs["openrouter"]
+	catalog.models = map[string]ModelInfo{"model": {ID: "model", ContextLength: 1000}}
+	catalog.fetched = time.Now().Add(-2 * catalogTTL)
+
+	done := make(chan ModelInfo)
+	go func() {
+		model, _ := client.LookupModel(context.Background(), "model")
+		done <- model
+	}()
+	select {
+	case model := <-done:
+		if model.ContextLength != 1000 {
+			t.Errorf("ContextLength = %d during refresh, want the stale 1000", model.ContextLength)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("LookupModel() blocked on the refresh")
+	}
+
+	close(provider.hold)
+	deadline := time.Now().Add(time.Second)
+	for {
+		model, _ := client.LookupModel(context.Background(), "model")
+		if model.ContextLength == 2000 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("catalog was not refreshed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if lists := provider.listCount(); lists != 1 {
+		t.Errorf("ListModels called %d times, want 1", lists)
+	}
+}
+
+func TestModelsUsesCatalog(t *testing.T) {
+	provider := &listingProvider{
+		fakeProvider: &fakeProvider{name: "openrouter"},
+		models:       []ModelInfo{{ID: "b"}, {ID: "a"}},
+	}
+	client := newTestClient(0, 0, provider)
+	for i := 0; i < 3; i++ {
+		models, err := client.Models(context.Background())
+		if err != nil || len(models) != 2 || models[0].ID != "b" {
+			t.Fatalf("Models() = %v, %v", models, err)
+		}
+	}
+	if lists := provider.listCount(); lists != 1 {
+		t.Errorf("ListModels called %d times, want 1", lists)
+	}
+
+	if _, err := newTestClient(0, 0, &fakeProvider{name: "local"}).Models(context.Background()); err == nil {
+		t.Error("Models() without a model list succeeded")
+	}
+}
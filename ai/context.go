@@ -0,0 +1,236 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ContextStrategy стратегия сокращения истории, не помещающейся в контекст модели
+type ContextStrategy string
+
+const (
+	// StrategyDropOldest удаляет самые старые сообщения, пока история не поместится
+	StrategyDropOldest ContextStrategy = "drop-oldest"
+	// StrategySlidingWindow оставляет только последние WindowMessages сообщений
+	StrategySlidingWindow ContextStrategy = "sliding-window"
+	// StrategySummarize заменяет старые сообщения их кратким содержанием,
+	// которое дополняется по мере роста разговора (см. Condense)
+	StrategySummarize ContextStrategy = "summarize"
+)
+
+// messageOverheadTokens служебные токены на каждое сообщение (роль, разделители)
+const messageOverheadTokens = 4
+
+// summaryPrompt инструкция для сжатия старой части разговора
+const summaryPrompt = "Кратко перескажи разговор ниже: ключевые факты о пользователе, его вопросы, " +
+	"принятые решения и договоренности. Пиши сжато, от третьего лица, без вступлений."
+
+// summaryNotePrefix начало системной заметки с кратким содержанием
+const summaryNotePrefix = "Краткое содержание предыдущей части разговора:\n"
+
+// ContextConfig настройки управления контекстом
+type ContextConfig struct {
+	Strategy             ContextStrategy
+	WindowMessages       int // Размер окна для sliding-window
+	DefaultContextLength int // Размер контекста, если модель не найдена в списке моделей
+	SummaryThreshold     int // Сколько несжатых сообщений допускается до обновления содержания
+	SummaryKeepRecent    int // Сколько последних сообщений всегда остаются дословными
+}
+
+// Summary краткое содержание начала разговора
+type Summary struct {
+	Text  string // Текст краткого содержания
+	Count int    // Сколько первых сообщений истории в него вошло
+}
+
+// ContextManager следит, чтобы запрос с историей поместился в контекст модели
+// вместе с зарезервированными под ответ MaxTokens. Системный промпт и
+// последнее сообщение пользователя сохраняются всегда.
+type ContextManager struct {
+	config ContextConfig
+}
+
+// NewContextManager создает менеджер контекста
+func NewContextManager(config ContextConfig) *ContextManager {
+	if config.Strategy == "" {
+		config.Strategy = StrategyDropOldest
+	}
+	if config.DefaultContextLength <= 0 {
+		config.DefaultContextLength = 8192
+	}
+	if config.SummaryThreshold <= 0 {
+		config.SummaryThreshold = 30
+	}
+	if config.SummaryKeepRecent <= 0 || config.SummaryKeepRecent >= config.SummaryThreshold {
+		config.SummaryKeepRecent = config.SummaryThreshold / 3
+	}
+	return &ContextManager{config: config}
+}
+
+// Strategy возвращает выбранную стратегию
+func (m *ContextManager) Strategy() ContextStrategy {
+	return m.config.Strategy
+}
+
+// ContextLength возвращает размер контекста модели, которой client отправит
+// запрос. Если клиент дублирует запрос (см. WithHedge), берется меньший из
+// контекстов обеих моделей: ответить может любая из них.
+func (m *ContextManager) ContextLength(ctx context.Context, client *Client) int {
+	length := m.modelContextLength(ctx, client)
+	if client.hedge != nil {
+		length = min(length, m.modelContextLength(ctx, client.hedge))
+	}
+	return length
+}
+
+// modelContextLength возвращает размер контекста основной модели клиента
+func (m *ContextManager) modelContextLength(ctx context.Context, client *Client) int {
+	if model, ok := client.LookupModel(ctx, client.Model()); ok && model.ContextLength > 0 {
+		return model.ContextLength
+	}
+	return m.config.DefaultContextLength
+}
+
+// Fit сокращает историю сообщений выбранной стратегией так, чтобы запрос
+// поместился в контекст модели, которой его отправит client
+func (m *ContextManager) Fit(ctx context.Context, client *Client, messages []ChatMessage) ([]ChatMessage, error) {
+	if len(messages) == 0 {
+		return messages, nil
+	}
+
+	// Ведущие системные сообщения и последнее сообщение неприкосновенны
+	head := 0
+	for head < len(messages)-1 && messages[head].Role == "system" {
+		head++
+	}
+	system := messages[:head]
+	history := messages[head : len(messages)-1]
+	last := messages[len(messages)-1]
+
+	budget := m.ContextLength(ctx, client) - client.MaxTokens() -
+		EstimateMessagesTokens(system) - EstimateMessagesTokens([]ChatMessage{last})
+	if budget < 0 {
+		return nil, fmt.Errorf("message does not fit into model context: %w", ErrContextTooLong)
+	}
+
+	if m.config.Strategy == StrategySlidingWindow && m.config.WindowMessages > 0 && len(history) > m.config.WindowMessages {
+		history = trimLeadingAssistant(history[len(history)-m.config.WindowMessages:])
+	}
+
+	// Даже после сжатия (summarize) история может не поместиться целиком:
+	// в этом случае отбрасываются самые старые дословные сообщения
+	kept := dropOldest(history, budget)
+
+	result := make([]ChatMessage, 0, len(system)+len(kept)+1)
+	result = append(result, system...)
+	result = append(result, kept...)
+	result = append(result, last)
+	return result, nil
+}
+
+// Condense дополняет краткое содержание разговора, когда число несжатых
+// сообщений превышает SummaryThreshold. В содержание добавляются только
+// новые сообщения (кроме SummaryKeepRecent последних), поэтому оно
+// обновляется инкрементально, а не пересчитывается с начала разговора.
+// Содержание составляет client. Возвращает новое содержание и признак того,
+// что оно изменилось.
+func (m *ContextManager) Condense(ctx context.Context, client *Client, history []ChatMessage, summary Summary) (Summary, bool, error) {
+	if summary.Count > len(history) {
+		// История короче сохраненного содержания - содержание устарело
+		summary = Summary{}
+	}
+	if len(history)-summary.Count <= m.config.SummaryThreshold {
+		return summary, false, nil
+	}
+
+	upTo := len(history) - m.config.SummaryKeepRecent
+	// Дословная часть не должна начинаться с ответа ассистента
+	for upTo < len(history) && history[upTo].Role == "assistant" {
+		upTo++
+	}
+
+	text, err := client.Summarize(ctx, summary.Text, history[summary.Count:upTo])
+	if err != nil {
+		return summary, false, err
+	}
+
+	return Summary{Text: text, Count: upTo}, true, nil
+}
+
+// SummaryNote возвращает системное сообщение с кратким содержанием разговора
+func SummaryNote(summary Summary) ChatMessage {
+	return ChatMessage{Role: "system", Content: summaryNotePrefix + summary.Text}
+}
+
+// Summarize сжимает сообщения в краткое содержание. Если передано
+// предыдущее содержание, оно дополняется новыми сообщениями.
+func (c *Client) Summarize(ctx context.Context, previous string, messages []ChatMessage) (string, error) {
+	var transcript strings.Builder
+	if previous != "" {
+		transcript.WriteString("Ранее: ")
+		transcript.WriteString(previous)
+		transcript.WriteString("\n\n")
+	}
+	for _, msg := range messages {
+		role := "Пользователь"
+		if msg.Role == "assistant" {
+			role = "Ассистент"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", role, msg.Content)
+	}
+
+	result, err := c.Chat(ctx, []ChatMessage{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: transcript.String()},
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
+
+// dropOldest удаляет самые старые сообщения, пока история не уложится в budget токенов
+func dropOldest(history []ChatMessage, budget int) []ChatMessage {
+	total := EstimateMessagesTokens(history)
+	start := 0
+	for start < len(history) && total > budget {
+		total -= EstimateMessagesTokens(history[start : start+1])
+		start++
+	}
+	return trimLeadingAssistant(history[start:])
+}
+
+// trimLeadingAssistant убирает ответы ассистента в начале истории,
+// оставшиеся без вопроса пользователя после обрезки
+func trimLeadingAssistant(history []ChatMessage) []ChatMessage {
+	for len(history) > 0 && history[0].Role == "assistant" {
+		history = history[1:]
+	}
+	return history
+}
+
+// EstimateTokens приблизительно оценивает число токенов в тексте без
+// токенизатора: около 4 символов на токен для латиницы и около 2 для
+// кириллицы и других алфавитов. Оценка намеренно завышена.
+func EstimateTokens(text string) int {
+	var ascii, other int
+	for _, r := range text {
+		if r <= unicode.MaxASCII {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + (other+1)/2
+}
+
+// EstimateMessagesTokens оценивает число токенов в сообщениях с учетом служебных
+func EstimateMessagesTokens(messages []ChatMessage) int {
+	total := 0
+	for _, msg := range messages {
+		total += EstimateTokens(msg.Content) + messageOverheadTokens
+	}
+	return total
+}
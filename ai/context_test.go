@@ -0,0 +1,53 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFitUsesRequestModel(t *testing.T) {
+	small := &listingProvider{
+		fakeProvider: &fakeProvider{name: "small"},
+		models: []ModelInfo{
+			{ID: "small-model", ContextLength: 1000},
+			{ID: "big-model", ContextLength: 100000},
+		},
+	}
+	big := &listingProvider{
+		fakeProvider: &fakeProvider{name: "big"},
+		models:       []ModelInfo{{ID: "big-model", ContextLength: 100000}},
+	}
+	client := newTestClient(0, 0, small, big)
+	client.config.MaxTokens = 100
+	manager := NewContextManager(ContextConfig{DefaultContextLength: 8192})
+	messages := []ChatMessage{
+		{Role: "system", Content: "Будь краток."},
+		{Role: "user", Content: strings.Repeat("длинное сообщение ", 500)},
+	}
+
+	site, _ := client.WithProvider("big")
+	hedged, _ := site.WithHedge(Hedge{Provider: "small"})
+	tests := []struct {
+		name   string
+		client *Client
+		fits   bool
+	}{
+		{"default model", client, false},
+		{"site provider", site, true},
+		{"site model", client.WithModel("big-model"), true},
+		{"hedge with a smaller model", hedged, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := manager.Fit(context.Background(), tt.client, messages)
+			if tt.fits && err != nil {
+				t.Errorf("Fit() = %v", err)
+			}
+			if !tt.fits && !errors.Is(err, ErrContextTooLong) {
+				t.Errorf("Fit() = %v, want ErrContextTooLong", err)
+			}
+		})
+	}
+}
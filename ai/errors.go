@@ -0,0 +1,79 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Ошибки клиента, по которым сервер выбирает ответ пользователю.
+// Проверяются через errors.Is: ошибки провайдеров сводятся к ним по классу.
+var (
+	// ErrRateLimited провайдер отклонил запрос из-за превышения лимита
+	ErrRateLimited = errors.New("AI provider rate limit exceeded")
+	// ErrContextTooLong запрос не помещается в контекст модели
+	ErrContextTooLong = errors.New("request does not fit into the model context")
+	// ErrAuth провайдер не принял ключ API
+	ErrAuth = errors.New("AI provider rejected the credentials")
+	// ErrContentFiltered запрос или ответ заблокирован фильтром содержимого
+	ErrContentFiltered = errors.New("content blocked by the AI provider filter")
+	// ErrTimeout провайдер не ответил за отведенное время
+	ErrTimeout = errors.New("AI provider timed out")
+	// ErrNoProvider нет ни одного настроенного или доступного провайдера
+	ErrNoProvider = errors.New("no AI provider available")
+)
+
+// Is сводит ошибку провайдера к ошибке клиента по ее классу
+func (e *ProviderError) Is(target error) bool {
+	switch e.Kind {
+	case ErrorRateLimited:
+		return target == ErrRateLimited
+	case ErrorContextLength:
+		return target == ErrContextTooLong
+	case ErrorAuth:
+		return target == ErrAuth
+	case ErrorContentFiltered:
+		return target == ErrContentFiltered
+	}
+	return false
+}
+
+// filteredReasons причины завершения, с которыми провайдеры возвращают
+// пустой ответ вместо заблокированного фильтром
+var filteredReasons = map[string]bool{
+	"content_filter": true, // OpenAI, OpenRouter
+	"refusal":        true, // Anthropic
+}
+
+// filteredError возвращает ошибку, если провайдер ответил успешно, но
+// фильтр содержимого не оставил в ответе текста
+func filteredError(provider Provider, response *ChatResponse) error {
+	if response.Content != "" || !filteredReasons[response.FinishReason] {
+		return nil
+	}
+	return &ProviderError{
+		Provider: provider.Name(),
+		Kind:     ErrorContentFiltered,
+		Message:  "response blocked, finish reason " + response.FinishReason,
+	}
+}
+
+// failure приводит ошибку последнего провайдера к ошибке клиента:
+// истекшее время запроса становится ErrTimeout, отсутствие провайдеров -
+// ErrNoProvider. Исходная ошибка сохраняется для лога.
+func failure(ctx context.Context, err error) error {
+	if err == nil {
+		return ErrNoProvider
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || isTimeout(err) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
+
+// isTimeout распознает таймаут HTTP клиента и сети
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
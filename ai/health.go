@@ -0,0 +1,195 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthTimeout ограничение времени одной проверки провайдера
+const healthTimeout = 10 * time.Second
+
+// HealthStatus состояние провайдера по результатам фоновых проверок
+type HealthStatus struct {
+	Provider    string     `json:"provider"`
+	Model       string     `json:"model"`
+	Healthy     bool       `json:"healthy"`
+	LastCheck   *time.Time `json:"last_check,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	LatencyMs   int64      `json:"latency_ms,omitempty"`
+
+	Circuit CircuitState `json:"circuit"` // Состояние circuit breaker по реальным запросам
+}
+
+// HealthProber периодически проверяет провайдеров дешевыми запросами и
+// хранит результат, чтобы статус сервера не требовал запроса к модели
+type HealthProber struct {
+	client    *Client
+	providers []Provider
+	interval  time.Duration
+
+	mu     sync.RWMutex
+	status map[string]HealthStatus
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewHealthProber создает проверку провайдеров клиента с периодом interval.
+// Проверки начинаются после Start.
+func NewHealthProber(client *Client, interval time.Duration) *HealthProber {
+	h := &HealthProber{
+		client:    client,
+		providers: client.Providers(),
+		interval:  interval,
+		status:    make(map[string]HealthStatus, len(client.providers)),
+		stop:      make(chan struct{}),
+	}
+	for _, provider := range h.providers {
+		h.status[provider.Name()] = HealthStatus{Provider: provider.Name(), Model: provider.Model()}
+	}
+	return h
+}
+
+// Inherit переносит последние результаты проверок провайдеров с теми же
+// именами, чтобы после перезагрузки конфигурации сервер не выглядел
+// недоступным до первой проверки
+func (h *HealthProber) Inherit(previous *HealthProber) {
+	if previous == nil {
+		return
+	}
+	for _, status := range previous.Status() {
+		if current, ok := h.status[status.Provider]; ok {
+			status.Model = current.Model
+			h.status[status.Provider] = status
+		}
+	}
+}
+
+// Start сразу проверяет провайдеров и затем повторяет проверку каждые
+// interval до вызова Stop
+func (h *HealthProber) Start() {
+	go func() {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		h.Probe(context.Background())
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				h.Probe(context.Background())
+			}
+		}
+	}()
+}
+
+// Stop останавливает фоновые проверки
+func (h *HealthProber) Stop() {
+	h.stopOnce.Do(func() { close(h.stop) })
+}
+
+// Probe проверяет всех провайдеров параллельно и обновляет их состояние
+func (h *HealthProber) Probe(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, provider := range h.providers {
+		wg.Add(1)
+		go func(provider Provider) {
+			defer wg.Done()
+
+			probeCtx, cancel := context.WithTimeout(ctx, healthTimeout)
+			start := time.Now()
+			err := checkProvider(probeCtx, provider)
+			cancel()
+			h.record(provider.Name(), start, err)
+		}(provider)
+	}
+	wg.Wait()
+}
+
+func (h *HealthProber) record(name string, start time.Time, err error) {
+	now := time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status := h.status[name]
+	status.LastCheck = &now
+	status.Healthy = err == nil
+	if err == nil {
+		status.LastSuccess = &now
+		status.LatencyMs = now.Sub(start).Milliseconds()
+	} else {
+		status.LastError = err.Error()
+		status.LastErrorAt = &now
+	}
+	h.status[name] = status
+}
+
+// Status возвращает состояние провайдеров в порядке приоритета
+func (h *HealthProber) Status() []HealthStatus {
+	circuits := h.client.Circuits()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	statuses := make([]HealthStatus, 0, len(h.providers))
+	for _, provider := range h.providers {
+		status := h.status[provider.Name()]
+		status.Circuit = circuits[provider.Name()]
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Ready сообщает, доступен ли хотя бы один провайдер: последняя проверка
+// прошла успешно и circuit breaker не отключил его
+func (h *HealthProber) Ready() bool {
+	for _, status := range h.Status() {
+		if status.Healthy && status.Circuit.State != CircuitOpen {
+			return true
+		}
+	}
+	return false
+}
+
+// checkProvider проверяет провайдера самым дешевым из доступных способов:
+// собственной проверкой, списком моделей или запросом на один токен
+func checkProvider(ctx context.Context, provider Provider) error {
+	if checker, ok := provider.(HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	if lister, ok := provider.(ModelLister); ok {
+		_, err := lister.ListModels(ctx)
+		return err
+	}
+	_, err := provider.Chat(ctx, ChatRequest{
+		Messages:  []ChatMessage{{Role: "user", Content: "ping"}},
+		MaxTokens: 1,
+	})
+	return err
+}
+
+// checkEndpoint выполняет запрос проверки и возвращает ошибку, если
+// сервер не ответил успешно
+func checkEndpoint(httpClient *http.Client, req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
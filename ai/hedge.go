@@ -0,0 +1,189 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Hedge второй, параллельный запрос того же разговора к другому провайдеру
+// или модели: ответ берется у того, кто ответит первым, второй запрос
+// отменяется
+type Hedge struct {
+	Provider string        // Провайдер второго запроса, пусто - основной провайдер клиента
+	Model    string        // Модель второго запроса, пусто - модель этого провайдера
+	Delay    time.Duration // Через сколько отправить второй запрос, если ответа еще нет; 0 - сразу
+}
+
+// errRaceLost причина отмены запроса, который ответил позже соперника
+var errRaceLost = errors.New("another request answered first")
+
+// WithHedge возвращает копию клиента, которая дублирует каждый запрос по
+// настройкам hedge. Второй запрос идет к одному провайдеру без fallback.
+// Возвращает false, если провайдера hedge.Provider нет.
+func (c *Client) WithHedge(hedge Hedge) (*Client, bool) {
+	second := c
+	if hedge.Provider != "" {
+		var ok bool
+		if second, ok = c.WithProvider(hedge.Provider); !ok {
+			return c, false
+		}
+	}
+	if hedge.Model != "" {
+		second = second.WithModel(hedge.Model)
+	}
+	if len(second.providers) == 0 {
+		return c, false
+	}
+
+	leg := *second
+	leg.providers = second.providers[:1]
+	leg.hedge = nil
+
+	clone := *c
+	clone.hedge = &leg
+	clone.hedgeDelay = hedge.Delay
+	return &clone, true
+}
+
+// raceResult результат одного из двух запросов гонки
+type raceResult struct {
+	leg    int
+	result *ChatResult
+	err    error
+}
+
+// race отправляет запрос основной цепочкой провайдеров клиента и через
+// hedgeDelay (или сразу после ошибки основного запроса) - вторым
+// провайдером. Без onDelta побеждает первый успешный ответ, в потоковом
+// режиме - первый полученный фрагмент. Проигравший запрос отменяется, его
+// расход учитывается (см. abandoned).
+func (c *Client) race(ctx context.Context, messages []ChatMessage, onDelta func(string) error) (*ChatResult, error) {
+	primary := *c
+	primary.hedge = nil
+	legs := [2]*Client{&primary, c.hedge}
+
+	var cancels [2]context.CancelCauseFunc
+	var contexts [2]context.Context
+	for i := range legs {
+		contexts[i], cancels[i] = context.WithCancelCause(ctx)
+	}
+
+	// Фрагменты передаются в onDelta под gate: после выхода из race ни один
+	// запрос уже не пишет в ответ, даже если еще не заметил отмену. race
+	// возвращается только после завершения обоих запросов.
+	var gate sync.Mutex
+	closed := false
+	var wg sync.WaitGroup
+	defer func() {
+		gate.Lock()
+		closed = true
+		gate.Unlock()
+		for _, cancel := range cancels {
+			cancel(context.Canceled)
+		}
+		wg.Wait()
+	}()
+
+	// winner - номер победившего запроса, -1 пока победителя нет. Соперник
+	// отменяется до того, как победа станет видна, поэтому его запрос к
+	// провайдеру завершается как отмененный и не считается ошибкой провайдера.
+	var mu sync.Mutex
+	winner := -1
+	claim := func(leg int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if winner == -1 {
+			cancels[1-leg](errRaceLost)
+			winner = leg
+		}
+		return winner == leg
+	}
+
+	results := make(chan raceResult, len(legs))
+	start := func(leg int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var result *ChatResult
+			var err error
+			if onDelta == nil {
+				result, err = legs[leg].Chat(contexts[leg], messages)
+			} else {
+				result, err = legs[leg].ChatStream(contexts[leg], messages, func(delta string) error {
+					if !claim(leg) {
+						return errRaceLost
+					}
+					gate.Lock()
+					defer gate.Unlock()
+					if closed {
+						return context.Canceled
+					}
+					return onDelta(delta)
+				})
+			}
+			results <- raceResult{leg: leg, result: result, err: err}
+		}()
+	}
+
+	start(0)
+	started, pending := 1, 1
+	timer := time.NewTimer(c.hedgeDelay)
+	defer timer.Stop()
+
+	var errs [2]error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			mu.Lock()
+			decided := winner != -1
+			mu.Unlock()
+			if !decided && started < len(legs) {
+				start(1)
+				started++
+				pending++
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil && claim(r.leg) {
+				return r.result, nil
+			}
+			mu.Lock()
+			current := winner
+			mu.Unlock()
+			if current == r.leg {
+				// Победитель прервался после первых фрагментов ответа
+				return nil, r.err
+			}
+			if current != -1 {
+				continue
+			}
+			errs[r.leg] = r.err
+			// Основной запрос не удался до срока - второй отправляется сразу
+			if started < len(legs) {
+				start(1)
+				started++
+				pending++
+			}
+		case <-ctx.Done():
+			return nil, failure(ctx, ctx.Err())
+		}
+	}
+
+	if errs[0] != nil {
+		return nil, errs[0]
+	}
+	return nil, errs[1]
+}
+
+// abandoned учитывает запрос, отмененный в пользу соперника. Провайдер
+// уже принял запрос и мог начать ответ, поэтому расход записывается
+// оценкой: запрос по его длине, ответ - по тексту received, который
+// провайдер успел передать в потоке.
+func (c *Client) abandoned(ctx context.Context, provider Provider, req ChatRequest, received string, start time.Time) {
+	if context.Cause(ctx) != errRaceLost {
+		return
+	}
+	c.newResult(context.WithoutCancel(ctx), provider, req.Messages, &ChatResponse{Model: req.Model, Content: received}, start)
+}
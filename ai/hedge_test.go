@@ -0,0 +1,182 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// usageRecorder собирает записи расхода клиента
+type usageRecorder struct {
+	mu      sync.Mutex
+	results []ChatResult
+}
+
+func (r *usageRecorder) hook(result *ChatResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, *result)
+}
+
+func (r *usageRecorder) providers() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for _, result := range r.results {
+		names = append(names, result.Provider)
+	}
+	return names
+}
+
+// newHedgedClient клиент с основным провайдером primary и вторым запросом
+// к hedge через delay
+func newHedgedClient(t *testing.T, primary, hedge *fakeProvider, delay time.Duration) (*Client, *usageRecorder) {
+	t.Helper()
+	recorder := &usageRecorder{}
+	client := newTestClient(0, 0, primary, hedge)
+	client.usageHook = recorder.hook
+	hedged, ok := client.WithHedge(Hedge{Provider: hedge.name, Delay: delay})
+	if !ok {
+		t.Fatal("WithHedge() = false")
+	}
+	return hedged, recorder
+}
+
+func TestHedgeDelay(t *testing.T) {
+	messages := []ChatMessage{{Role: "user", Content: "Привет"}}
+
+	t.Run("fast primary", func(t *testing.T) {
+		primary := &fakeProvider{name: "primary"}
+		hedge := &fakeProvider{name: "hedge"}
+		client, recorder := newHedgedClient(t, primary, hedge, 200*time.Millisecond)
+
+		result, err := client.Chat(context.Background(), messages)
+		if err != nil || result.Provider != "primary" {
+			t.Fatalf("result = %+v, %v", result, err)
+		}
+		if calls, _ := hedge.stats(); calls != 0 {
+			t.Errorf("hedge calls = %d, want 0 before the delay", calls)
+		}
+		if got := recorder.providers(); strings.Join(got, ",") != "primary" {
+			t.Errorf("usage = %v", got)
+		}
+	})
+
+	t.Run("slow primary", func(t *testing.T) {
+		primary := &fakeProvider{name: "primary", delay: time.Second}
+		hedge := &fakeProvider{name: "hedge"}
+		client, recorder := newHedgedClient(t, primary, hedge, 20*time.Millisecond)
+
+		start := time.Now()
+		result, err := client.Chat(context.Background(), messages)
+		if err != nil || result.Provider != "hedge" {
+			t.Fatalf("result = %+v, %v", result, err)
+		}
+		if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > 500*time.Millisecond {
+			t.Errorf("answered in %v", elapsed)
+		}
+		// Проигравший отменен и учтен в расходе
+		if _, cancelled := primary.stats(); cancelled != 1 {
+			t.Errorf("primary cancelled = %d, want 1", cancelled)
+		}
+		if got := recorder.providers(); len(got) != 2 {
+			t.Errorf("usage = %v, want both requests", got)
+		}
+	})
+
+	t.Run("primary fails before the delay", func(t *testing.T) {
+		primary := &fakeProvider{name: "primary", errs: []error{apiError(http.StatusServiceUnavailable, "unavailable")}}
+		hedge := &fakeProvider{name: "hedge"}
+		client, _ := newHedgedClient(t, primary, hedge, time.Second)
+
+		start := time.Now()
+		result, err := client.Chat(context.Background(), messages)
+		if err != nil || result.Provider != "hedge" {
+			t.Fatalf("result = %+v, %v", result, err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("hedge waited for the delay after the primary failed: %v", elapsed)
+		}
+	})
+}
+
+func TestHedgeStream(t *testing.T) {
+	primary := &fakeProvider{name: "primary", delay: 10 * time.Millisecond, deltas: []string{"Пр", "ив", "ет"}}
+	hedge := &fakeProvider{name: "hedge", delay: 300 * time.Millisecond}
+	client, _ := newHedgedClient(t, primary, hedge, 0)
+
+	var deltas []string
+	result, err := client.ChatStream(context.Background(), []ChatMessage{{Role: "user", Content: "Привет"}}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil || result.Provider != "primary" {
+		t.Fatalf("result = %+v, %v", result, err)
+	}
+	if strings.Join(deltas, "") != "Привет" {
+		t.Errorf("deltas = %q, want only the winner's", deltas)
+	}
+	if calls, cancelled := hedge.stats(); calls != 1 || cancelled != 1 {
+		t.Errorf("hedge calls %d, cancelled %d; want the loser cancelled", calls, cancelled)
+	}
+}
+
+func TestHedgeStopsWritingOnDeadline(t *testing.T) {
+	primary := &fakeProvider{name: "primary", delay: 5 * time.Millisecond, deltas: strings.Split(strings.Repeat("x", 100), "")}
+	hedge := &fakeProvider{name: "hedge", delay: time.Second}
+	client, _ := newHedgedClient(t, primary, hedge, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var returned atomic.Bool
+	var written []string // Без синхронизации: гонку найдет go test -race
+	_, err := client.ChatStream(ctx, []ChatMessage{{Role: "user", Content: "Привет"}}, func(delta string) error {
+		if returned.Load() {
+			t.Error("onDelta called after ChatStream returned")
+		}
+		written = append(written, delta)
+		return nil
+	})
+	returned.Store(true)
+	written = append(written, "error event")
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("error = %v, want ErrTimeout", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if len(written) >= 100 {
+		t.Errorf("the whole stream was written after the deadline")
+	}
+}
+
+func TestAbandonedCountsStreamedText(t *testing.T) {
+	recorder := &usageRecorder{}
+	provider := &fakeProvider{name: "hedge"}
+	client := newTestClient(0, 0, provider)
+	client.usageHook = recorder.hook
+
+	req := ChatRequest{Messages: []ChatMessage{{Role: "user", Content: "Расскажи сказку"}}}
+	received := "Жили-были дед да баба"
+
+	// Запрос, отмененный не гонкой, не учитывается
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(context.Canceled)
+	client.abandoned(ctx, provider, req, received, time.Now())
+
+	ctx, cancel = context.WithCancelCause(context.Background())
+	cancel(errRaceLost)
+	client.abandoned(ctx, provider, req, received, time.Now())
+
+	if len(recorder.results) != 1 {
+		t.Fatalf("usage entries = %d, want 1", len(recorder.results))
+	}
+	usage := recorder.results[0].Usage
+	if usage.PromptTokens != EstimateMessagesTokens(req.Messages) || usage.CompletionTokens != EstimateTokens(received) || usage.CompletionTokens == 0 {
+		t.Errorf("usage = %+v", usage)
+	}
+}
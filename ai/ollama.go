@@ -0,0 +1,311 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	// Локальный сервер Ollama, API ключ не требуется
+	RegisterProvider("ollama", ProviderType{
+		DefaultURL: "http://localhost:11434",
+		New:        newOllamaProvider,
+	})
+}
+
+// ollamaProvider провайдер для нативного API Ollama (/api/chat, /api/tags)
+type ollamaProvider struct {
+	config     ProviderConfig
+	httpClient *http.Client
+}
+
+func newOllamaProvider(cfg ProviderConfig, httpClient *http.Client) (Provider, error) {
+	return &ollamaProvider{
+		config:     cfg,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Name возвращает имя провайдера
+func (p *ollamaProvider) Name() string {
+	return p.config.Name
+}
+
+// Model возвращает модель по умолчанию
+func (p *ollamaProvider) Model() string {
+	return p.config.Model
+}
+
+// Chat отправляет запрос в /api/chat без потоковой передачи
+func (p *ollamaProvider) Chat(ctx context.Context, chatReq ChatRequest) (*ChatResponse, error) {
+	req, err := p.newChatRequest(ctx, chatReq, false)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := readBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var ollamaResp ollamaChatResponse
+	if err := json.Unmarshal(responseBody, &ollamaResp); err != nil {
+		if resp.StatusCode >= 400 {
+			return nil, newAPIError(p.config.Name, resp.StatusCode, resp.Header, errorBody(responseBody))
+		}
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if ollamaResp.Error != "" {
+		return nil, newAPIError(p.config.Name, resp.StatusCode, resp.Header, ollamaResp.Error)
+	}
+
+	if ollamaResp.Message.Content == "" {
+		return nil, fmt.Errorf("no response from %s", p.config.Name)
+	}
+
+	result := &ChatResponse{Content: ollamaResp.Message.Content}
+	ollamaResp.fill(result)
+	return result, nil
+}
+
+// ChatStream отправляет запрос в /api/chat и читает ответ в формате
+// NDJSON: по одному JSON объекту на строку до объекта с done: true,
+// который содержит расход токенов
+func (p *ollamaProvider) ChatStream(ctx context.Context, chatReq ChatRequest, onDelta func(string) error) (*ChatResponse, error) {
+	req, err := p.newChatRequest(ctx, chatReq, true)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Ошибка до начала потока приходит одним JSON объектом со статусом ошибки
+	if resp.StatusCode >= 400 {
+		responseBody, _ := readBody(resp)
+		var chunk ollamaChatResponse
+		if json.Unmarshal(responseBody, &chunk) == nil && chunk.Error != "" {
+			return nil, newAPIError(p.config.Name, resp.StatusCode, resp.Header, chunk.Error)
+		}
+		return nil, newAPIError(p.config.Name, resp.StatusCode, resp.Header, errorBody(responseBody))
+	}
+
+	result := &ChatResponse{Model: chatReq.Model}
+	if result.Model == "" {
+		result.Model = p.config.Model
+	}
+	var full strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			result.Content = full.String()
+			return result, fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			result.Content = full.String()
+			return result, newAPIError(p.config.Name, 0, nil, chunk.Error)
+		}
+
+		if delta := chunk.Message.Content; delta != "" {
+			full.WriteString(delta)
+			if err := onDelta(delta); err != nil {
+				result.Content = full.String()
+				return result, err
+			}
+		}
+		if chunk.Done {
+			chunk.fill(result)
+			break
+		}
+	}
+	result.Content = full.String()
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	if full.Len() == 0 {
+		return nil, fmt.Errorf("empty stream from %s", p.config.Name)
+	}
+
+	return result, nil
+}
+
+// ListModels возвращает локально загруженные модели через /api/tags
+func (p *ollamaProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.config.BaseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(req)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := readBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var tagsResp struct {
+		Models []struct {
+			Name    string `json:"name"`
+			Model   string `json:"model"`
+			Size    int64  `json:"size"`
+			Details struct {
+				Family            string `json:"family"`
+				ParameterSize     string `json:"parameter_size"`
+				QuantizationLevel string `json:"quantization_level"`
+			} `json:"details"`
+		} `json:"models"`
+		Error string `json:"error,omitempty"`
+	}
+	if resp.StatusCode >= 400 {
+		if json.Unmarshal(responseBody, &tagsResp) == nil && tagsResp.Error != "" {
+			return nil, newAPIError(p.config.Name, resp.StatusCode, resp.Header, tagsResp.Error)
+		}
+		return nil, newAPIError(p.config.Name, resp.StatusCode, resp.Header, errorBody(responseBody))
+	}
+	if err := json.Unmarshal(responseBody, &tagsResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if tagsResp.Error != "" {
+		return nil, newAPIError(p.config.Name, 0, nil, tagsResp.Error)
+	}
+
+	models := make([]ModelInfo, len(tagsResp.Models))
+	for i, model := range tagsResp.Models {
+		var details []string
+		for _, detail := range []string{model.Details.Family, model.Details.ParameterSize, model.Details.QuantizationLevel} {
+			if detail != "" {
+				details = append(details, detail)
+			}
+		}
+		details = append(details, fmt.Sprintf("%.1f GB", float64(model.Size)/(1<<30)))
+
+		models[i] = ModelInfo{
+			ID:          model.Name,
+			Name:        model.Name,
+			Description: strings.Join(details, ", "),
+		}
+	}
+	return models, nil
+}
+
+// CheckHealth проверяет, что сервер Ollama запущен
+func (p *ollamaProvider) CheckHealth(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.config.BaseURL+"/api/tags", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(req)
+	return checkEndpoint(p.httpClient, req)
+}
+
+// newChatRequest формирует HTTP запрос к /api/chat
+func (p *ollamaProvider) newChatRequest(ctx context.Context, chatReq ChatRequest, stream bool) (*http.Request, error) {
+	model := chatReq.Model
+	if model == "" {
+		model = p.config.Model
+	}
+
+	request := ollamaChatRequest{
+		Model:    model,
+		Messages: chatReq.Messages,
+		Stream:   stream,
+		Options: ollamaOptions{
+			Temperature: chatReq.Temperature,
+			NumPredict:  chatReq.MaxTokens,
+		},
+	}
+
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.config.BaseURL+"/api/chat", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	p.setHeaders(req)
+
+	return req, nil
+}
+
+// setHeaders добавляет заголовки из конфигурации. Ключ необязателен,
+// но передается, если Ollama стоит за прокси с авторизацией.
+func (p *ollamaProvider) setHeaders(req *http.Request) {
+	if p.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.APIKey)
+	}
+	for key, value := range p.config.Headers {
+		req.Header.Set(key, value)
+	}
+}
+
+// Внутренние структуры для Ollama API
+type ollamaChatRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	Options  ollamaOptions `json:"options"`
+}
+
+type ollamaOptions struct {
+	Temperature float32 `json:"temperature"`
+	NumPredict  int     `json:"num_predict,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Model   string `json:"model"`
+	Message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error,omitempty"`
+}
+
+// fill переносит в ответ модель, причину завершения и расход токенов
+// из итогового объекта (done: true)
+func (r *ollamaChatResponse) fill(result *ChatResponse) {
+	if r.Model != "" {
+		result.Model = r.Model
+	}
+	result.FinishReason = r.DoneReason
+	result.Usage = Usage{
+		PromptTokens:     r.PromptEvalCount,
+		CompletionTokens: r.EvalCount,
+		TotalTokens:      r.PromptEvalCount + r.EvalCount,
+	}
+}
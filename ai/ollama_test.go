@@ -0,0 +1,44 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOllamaListModelsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		is     error
+		kind   ErrorKind
+	}{
+		{"auth proxy", http.StatusUnauthorized, `{"error":"unauthorized"}`, ErrAuth, ErrorAuth},
+		{"bad gateway", http.StatusBadGateway, `<html>Bad gateway</html>`, nil, ErrorRetryable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			t.Cleanup(server.Close)
+			provider, err := newOllamaProvider(ProviderConfig{Name: "ollama", BaseURL: server.URL, Model: "llama3"}, server.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = provider.(ModelLister).ListModels(context.Background())
+			if got := errorKind(err); got != tt.kind {
+				t.Errorf("kind = %v, want %v (%v)", got, tt.kind, err)
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.is)
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	RegisterProvider("openrouter", ProviderType{
+		DefaultURL: "https://openrouter.ai/api/v1",
+		DefaultHeaders: map[string]string{
+			"HTTP-Referer": "https://ai-bot.local",
+			"X-Title":      "AI Bot",
+		},
+		RequiresKey: true,
+		New:         newOpenAIProvider,
+	})
+	RegisterProvider("openai", ProviderType{
+		DefaultURL:  "https://api.openai.com/v1",
+		RequiresKey: true,
+		New:         newOpenAIProvider,
+	})
+	// Любой сервер с OpenAI-совместимым API: llama.cpp, vLLM, LM Studio и т.п.
+	RegisterProvider("openai-compatible", ProviderType{
+		New: newOpenAIProvider,
+	})
+}
+
+// openAIProvider провайдер для OpenAI-совместимого Chat Completions API
+type openAIProvider struct {
+	config     ProviderConfig
+	httpClient *http.Client
+}
+
+func newOpenAIProvider(cfg ProviderConfig, httpClient *http.Client) (Provider, error) {
+	return &openAIProvider{
+		config:     cfg,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Name возвращает имя провайдера
+func (p *openAIProvider) Name() string {
+	return p.config.Name
+}
+
+// Model возвращает модель по умолчанию
+func (p *openAIProvider) Model() string {
+	return p.config.Model
+}
+
+// Chat отправляет запрос в Chat Completions API
+func (p *openAIProvider) Chat(ctx context.Context, chatReq ChatRequest) (*ChatResponse, error) {
+	req, err := p.newChatRequest(ctx, chatReq, false)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return p.readResponse(resp)
+}
+
+// ChatStream отправляет запрос с stream: true и разбирает поток
+// Server-Sent Events с дельтами ответа. Расход токенов приходит
+// в последнем событии (stream_options.include_usage).
+func (p *openAIProvider) ChatStream(ctx context.Context, chatReq ChatRequest, onDelta func(string) error) (*ChatResponse, error) {
+	req, err := p.newChatRequest(ctx, chatReq, true)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// При ошибке API отвечает обычным JSON, а не потоком
+	if resp.StatusCode >= 400 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		result, err := p.readResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+		if err := onDelta(result.Content); err != nil {
+			return result, err
+		}
+		return result, nil
+	}
+
+	result := &ChatResponse{Model: chatReq.Model, RequestID: upstreamRequestID(resp.Header)}
+	if result.Model == "" {
+		result.Model = p.config.Model
+	}
+	var full strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Пустые строки разделяют события, строки с ":" - комментарии (keep-alive)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			result.Content = full.String()
+			return result, fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			result.Content = full.String()
+			return result, chunk.Error.apiError(p.config.Name, 0, resp.Header)
+		}
+		if chunk.Model != "" {
+			result.Model = chunk.Model
+		}
+		if result.RequestID == "" {
+			result.RequestID = chunk.ID
+		}
+		if chunk.Usage != nil {
+			result.Usage = *chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		if reason := chunk.Choices[0].FinishReason; reason != nil {
+			result.FinishReason = *reason
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		if delta == "" {
+			continue
+		}
+		full.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			result.Content = full.String()
+			return result, err
+		}
+	}
+	result.Content = full.String()
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	// Ответ, заблокированный фильтром, приходит без текста, его разбирает filteredError
+	if full.Len() == 0 && !filteredReasons[result.FinishReason] {
+		return nil, fmt.Errorf("empty stream from %s", p.config.Name)
+	}
+
+	return result, nil
+}
+
+// ListModels получает список моделей через /models
+func (p *openAIProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.config.BaseURL+"/models", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(req)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := readBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, p.statusError(resp, responseBody)
+	}
+
+	var modelsResp OpenRouterModelsResponse
+	if err := json.Unmarshal(responseBody, &modelsResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return modelsResp.Data, nil
+}
+
+// CheckHealth проверяет доступность API и ключа. Список моделей OpenRouter
+// доступен без ключа, поэтому для него запрашиваются сведения о ключе.
+func (p *openAIProvider) CheckHealth(ctx context.Context) error {
+	path := "/models"
+	if strings.EqualFold(p.config.Type, "openrouter") {
+		path = "/key"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", p.config.BaseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(req)
+	return checkEndpoint(p.httpClient, req)
+}
+
+// newChatRequest формирует HTTP запрос к /chat/completions
+func (p *openAIProvider) newChatRequest(ctx context.Context, chatReq ChatRequest, stream bool) (*http.Request, error) {
+	openAIMessages := make([]openAIMessage, len(chatReq.Messages))
+	for i, msg := range chatReq.Messages {
+		openAIMessages[i] = openAIMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		}
+	}
+
+	model := chatReq.Model
+	if model == "" {
+		model = p.config.Model
+	}
+
+	request := openAIRequest{
+		Model:       model,
+		Messages:    openAIMessages,
+		MaxTokens:   chatReq.MaxTokens,
+		Temperature: chatReq.Temperature,
+		Stream:      stream,
+	}
+	if stream {
+		request.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.config.BaseURL+"/chat/completions", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	p.setHeaders(req)
+
+	return req, nil
+}
+
+// setHeaders добавляет авторизацию и заголовки из конфигурации
+func (p *openAIProvider) setHeaders(req *http.Request) {
+	if p.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.APIKey)
+	}
+	for key, value := range p.config.Headers {
+		req.Header.Set(key, value)
+	}
+}
+
+// readResponse разбирает обычный (не потоковый) ответ Chat Completions API
+func (p *openAIProvider) readResponse(resp *http.Response) (*ChatResponse, error) {
+	responseBody, err := readBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, p.statusError(resp, responseBody)
+	}
+
+	var openAIResp openAIResponse
+	if err := json.Unmarshal(responseBody, &openAIResp); err != nil {
+		// Страница прокси или балансировщика вместо ответа API: считаем
+		// временной ошибкой, чтобы сработали повтор и fallback
+		return nil, newAPIError(p.config.Name, resp.StatusCode, resp.Header, "unexpected response: "+errorBody(responseBody))
+	}
+
+	// OpenRouter может ответить 200 с ошибкой провайдера модели в теле
+	if openAIResp.Error != nil {
+		return nil, openAIResp.Error.apiError(p.config.Name, resp.StatusCode, resp.Header)
+	}
+
+	if len(openAIResp.Choices) == 0 {
+		return nil, fmt.Errorf("no response from %s", p.config.Name)
+	}
+
+	requestID := upstreamRequestID(resp.Header)
+	if requestID == "" {
+		requestID = openAIResp.ID
+	}
+	return &ChatResponse{
+		Content:      openAIResp.Choices[0].Message.Content,
+		Model:        openAIResp.Model,
+		FinishReason: openAIResp.Choices[0].FinishReason,
+		Usage:        openAIResp.Usage,
+		RequestID:    requestID,
+	}, nil
+}
+
+// statusError формирует ошибку ответа с HTTP статусом ошибки из конверта
+// ошибки API или, если его нет, из начала тела ответа
+func (p *openAIProvider) statusError(resp *http.Response, body []byte) error {
+	var envelope struct {
+		Error *openAIError `json:"error"`
+	}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error != nil {
+		return envelope.Error.apiError(p.config.Name, resp.StatusCode, resp.Header)
+	}
+	return newAPIError(p.config.Name, resp.StatusCode, resp.Header, errorBody(body))
+}
+
+// openAIError конверт ошибки OpenAI и OpenRouter. OpenAI передает code
+// строкой ("context_length_exceeded"), OpenRouter - числом, совпадающим с
+// HTTP статусом, и добавляет metadata с подробностями.
+type openAIError struct {
+	Message  string          `json:"message"`
+	Type     string          `json:"type"`
+	Code     json.RawMessage `json:"code"`
+	Param    string          `json:"param"`
+	Metadata *struct {
+		ProviderName string          `json:"provider_name"` // Провайдер модели за OpenRouter
+		Raw          json.RawMessage `json:"raw"`           // Исходная ошибка этого провайдера
+		Reasons      []string        `json:"reasons"`       // Причины отказа модерации
+		FlaggedInput string          `json:"flagged_input"`
+	} `json:"metadata"`
+}
+
+// apiError классифицирует ошибку. Числовой код OpenRouter заменяет HTTP
+// статус, если ошибка пришла в ответе 200 или внутри потока. Строковый код
+// входит в сообщение, по нему распознаются длина контекста и модерация.
+func (e *openAIError) apiError(provider string, status int, header http.Header) error {
+	var code int
+	if json.Unmarshal(e.Code, &code) == nil && code >= 400 && status < 400 {
+		status = code
+	}
+
+	message := e.Message
+	var tags []string
+	if message == "" {
+		message = e.Type
+	} else if e.Type != "" {
+		tags = append(tags, e.Type)
+	}
+	var name string
+	if json.Unmarshal(e.Code, &name) == nil && name != "" {
+		tags = append(tags, name)
+	}
+	if len(tags) > 0 {
+		message += " (" + strings.Join(tags, ", ") + ")"
+	}
+	if meta := e.Metadata; meta != nil {
+		if len(meta.Reasons) > 0 {
+			message += "; flagged for moderation: " + strings.Join(meta.Reasons, ", ")
+		}
+		if meta.ProviderName != "" {
+			message += "; provider " + meta.ProviderName
+			if raw := rawMessage(meta.Raw); raw != "" {
+				message += ": " + raw
+			}
+		}
+	}
+
+	// Статус ответа без ошибки (envelope в ответе 200) не определяет класс:
+	// он определяется по коду и типу ошибки из тела
+	if status < 400 {
+		err := newAPIError(provider, 0, header, message)
+		err.StatusCode = status
+		return err
+	}
+	return newAPIError(provider, status, header, message)
+}
+
+// rawMessage возвращает исходную ошибку провайдера из metadata.raw:
+// OpenRouter передает ее строкой с JSON или объектом
+func rawMessage(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var text string
+	if json.Unmarshal(raw, &text) != nil {
+		text = string(raw)
+	}
+	if text == "null" {
+		return ""
+	}
+	return errorBody([]byte(text))
+}
+
+// Внутренние структуры для API
+type openAIRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openAIMessage `json:"messages"`
+	MaxTokens   int             `json:"max_tokens"`
+	Temperature float32         `json:"temperature"`
+	Stream      bool            `json:"stream,omitempty"`
+
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index   int `json:"index"`
+		Message struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage Usage        `json:"usage"`
+	Error *openAIError `json:"error,omitempty"`
+}
+
+type openAIStreamChunk struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index int `json:"index"`
+		Delta struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *Usage       `json:"usage,omitempty"`
+	Error *openAIError `json:"error,omitempty"`
+}
@@ -0,0 +1,67 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAIErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   ErrorKind
+	}{
+		{"numeric code in 200", `{"message":"Rate limit exceeded","code":429}`, http.StatusOK, ErrorRateLimited},
+		{"string code in 200", `{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}`, http.StatusOK, ErrorAuth},
+		{"type in 200", `{"message":"Unsupported parameter","type":"invalid_request_error"}`, http.StatusOK, ErrorInvalidRequest},
+		{"context length in 200", `{"message":"Too long","code":"context_length_exceeded"}`, http.StatusOK, ErrorContextLength},
+		{"unknown error in 200", `{"message":"Internal error","type":"server_error"}`, http.StatusOK, ErrorRetryable},
+		{"status decides", `{"message":"Bad gateway","type":"invalid_request_error"}`, http.StatusBadGateway, ErrorRetryable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var envelope openAIError
+			if err := json.Unmarshal([]byte(tt.body), &envelope); err != nil {
+				t.Fatal(err)
+			}
+			err := envelope.apiError("openai", tt.status, nil)
+			if got := errorKind(err); got != tt.want {
+				t.Errorf("kind = %v, want %v (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestOpenAIStreamContentFilter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"gen-1\",\"model\":\"gpt-test\",\"choices\":[{\"delta\":{\"content\":\"\"},\"finish_reason\":\"content_filter\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+	provider, err := newOpenAIProvider(ProviderConfig{
+		Name:    "openai",
+		BaseURL: server.URL,
+		APIKey:  "test-key",
+		Model:   "gpt-test",
+	}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback := &fakeProvider{name: "fallback"}
+	client := newTestClient(2, 0, provider, fallback)
+
+	_, err = client.ChatStream(context.Background(), []ChatMessage{{Role: "user", Content: "Привет"}}, func(string) error { return nil })
+	if !errors.Is(err, ErrContentFiltered) {
+		t.Errorf("error = %v, want ErrContentFiltered", err)
+	}
+	if calls, _ := fallback.stats(); calls != 0 {
+		t.Errorf("fallback called %d times after a filtered response", calls)
+	}
+}
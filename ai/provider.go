@@ -0,0 +1,155 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProviderConfig конфигурация одного провайдера в цепочке fallback
+type ProviderConfig struct {
+	Name     string            // Уникальное имя провайдера (openrouter, openai, local...)
+	Type     string            // Тип провайдера из реестра (openrouter, openai, openai-compatible...)
+	BaseURL  string            // Базовый URL API, пустое значение - URL по умолчанию для типа
+	APIKey   string            // API ключ, может быть пустым для локальных серверов
+	Model    string            // Модель по умолчанию
+	Headers  map[string]string // Дополнительные HTTP заголовки
+	Priority int               // Порядок в цепочке: меньше - раньше
+}
+
+// ChatRequest запрос к провайдеру
+type ChatRequest struct {
+	Model       string
+	Messages    []ChatMessage
+	MaxTokens   int
+	Temperature float32
+}
+
+// Usage расход токенов на запрос
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ChatResponse ответ провайдера
+type ChatResponse struct {
+	Content      string
+	Model        string // Модель, фактически обработавшая запрос
+	FinishReason string // Причина завершения: stop, length и т.п.
+	Usage        Usage  // Нули, если провайдер не сообщил расход
+	RequestID    string // Идентификатор запроса у провайдера, если он известен
+}
+
+// Provider интерфейс провайдера AI
+type Provider interface {
+	// Name возвращает имя провайдера из конфигурации
+	Name() string
+	// Model возвращает модель по умолчанию
+	Model() string
+	// Chat отправляет запрос и возвращает полный ответ
+	Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error)
+	// ChatStream отправляет запрос и передает ответ по частям в onDelta.
+	// При ошибке возвращается ответ с уже полученной частью текста.
+	ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (*ChatResponse, error)
+}
+
+// ModelLister реализуется провайдерами, умеющими возвращать список моделей
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+}
+
+// HealthChecker реализуется провайдерами, доступность которых можно
+// проверить бесплатным запросом (список моделей, сведения о ключе)
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// ProviderType описание типа провайдера в реестре
+type ProviderType struct {
+	DefaultURL     string                                                              // URL по умолчанию
+	DefaultHeaders map[string]string                                                   // Заголовки по умолчанию
+	RequiresKey    bool                                                                // Без ключа провайдер считается не настроенным
+	New            func(cfg ProviderConfig, httpClient *http.Client) (Provider, error) // Конструктор
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]ProviderType{}
+)
+
+// RegisterProvider регистрирует тип провайдера. Повторная регистрация заменяет предыдущую.
+func RegisterProvider(typeName string, providerType ProviderType) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToLower(typeName)] = providerType
+}
+
+// ProviderTypes возвращает отсортированный список зарегистрированных типов
+func ProviderTypes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	types := make([]string, 0, len(registry))
+	for name := range registry {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func lookupProviderType(typeName string) (ProviderType, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	providerType, ok := registry[strings.ToLower(typeName)]
+	return providerType, ok
+}
+
+// buildProviders создает провайдеров из конфигурации в порядке приоритета.
+// Провайдеры без обязательного API ключа пропускаются.
+func buildProviders(configs []ProviderConfig, httpClient *http.Client) ([]Provider, error) {
+	sorted := make([]ProviderConfig, len(configs))
+	copy(sorted, configs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	var providers []Provider
+	for _, cfg := range sorted {
+		providerType, ok := lookupProviderType(cfg.Type)
+		if !ok {
+			return nil, fmt.Errorf("provider %q: unknown type %q (available: %s)", cfg.Name, cfg.Type, strings.Join(ProviderTypes(), ", "))
+		}
+		if providerType.RequiresKey && cfg.APIKey == "" {
+			continue
+		}
+
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = providerType.DefaultURL
+		}
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("provider %q: base URL is required for type %q", cfg.Name, cfg.Type)
+		}
+		cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+
+		headers := make(map[string]string, len(providerType.DefaultHeaders)+len(cfg.Headers))
+		for key, value := range providerType.DefaultHeaders {
+			headers[key] = value
+		}
+		for key, value := range cfg.Headers {
+			headers[key] = value
+		}
+		cfg.Headers = headers
+
+		provider, err := providerType.New(cfg, httpClient)
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", cfg.Name, err)
+		}
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
@@ -0,0 +1,386 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// retryBaseDelay пауза перед первым повтором, дальше удваивается
+	retryBaseDelay = 500 * time.Millisecond
+	// retryMaxDelay наибольшая пауза между повторами
+	retryMaxDelay = 8 * time.Second
+	// retryAfterLimit наибольший Retry-After, который стоит ждать; при
+	// большем запрос сразу переходит к следующему провайдеру
+	retryAfterLimit = 30 * time.Second
+	// maxResponseSize наибольший размер ответа API, который читается в
+	// память (список моделей OpenRouter занимает несколько мегабайт)
+	maxResponseSize = 16 << 20
+)
+
+// ErrorKind класс ошибки провайдера: от него зависят повтор, переход к
+// следующему провайдеру и счетчик circuit breaker
+type ErrorKind int
+
+const (
+	// ErrorRetryable временная ошибка: 5xx, сбой сети, обрыв ответа
+	ErrorRetryable ErrorKind = iota
+	// ErrorRateLimited превышен лимит провайдера (429), см. RetryAfter
+	ErrorRateLimited
+	// ErrorAuth неверный или отозванный ключ (401, 403)
+	ErrorAuth
+	// ErrorNotFound модель или адрес API не найдены у провайдера (404):
+	// повтор бесполезен, но другой провайдер может ответить
+	ErrorNotFound
+	// ErrorInvalidRequest ошибка в самом запросе (400, 422): другой
+	// провайдер ее не исправит
+	ErrorInvalidRequest
+	// ErrorContextLength запрос не помещается в контекст модели (413)
+	ErrorContextLength
+	// ErrorContentFiltered запрос или ответ заблокирован фильтром
+	// содержимого: отправлять его другому провайдеру не следует
+	ErrorContentFiltered
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorRateLimited:
+		return "rate_limited"
+	case ErrorAuth:
+		return "auth"
+	case ErrorNotFound:
+		return "not_found"
+	case ErrorInvalidRequest:
+		return "invalid_request"
+	case ErrorContextLength:
+		return "context_length"
+	case ErrorContentFiltered:
+		return "content_filtered"
+	}
+	return "retryable"
+}
+
+// ProviderError ошибка, которую вернул API провайдера
+type ProviderError struct {
+	Provider   string
+	Kind       ErrorKind
+	StatusCode int           // HTTP статус, 0 - ошибка пришла в потоке ответа
+	RetryAfter time.Duration // Из заголовка Retry-After, 0 - не задан
+	RequestID  string        // Идентификатор запроса у провайдера для обращения в поддержку
+	Message    string
+}
+
+func (e *ProviderError) Error() string {
+	message := e.Message
+	if e.RequestID != "" {
+		message += " (request " + e.RequestID + ")"
+	}
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s API error (HTTP %d): %s", e.Provider, e.StatusCode, message)
+	}
+	return fmt.Sprintf("%s API error: %s", e.Provider, message)
+}
+
+// newAPIError классифицирует ошибку API по HTTP статусу, а для ошибок
+// внутри потока (status 0) - по тексту сообщения
+func newAPIError(provider string, status int, header http.Header, message string) *ProviderError {
+	err := &ProviderError{Provider: provider, StatusCode: status, Message: message}
+	if header != nil {
+		err.RetryAfter = parseRetryAfter(header.Get("Retry-After"))
+		err.RequestID = upstreamRequestID(header)
+	}
+
+	lower := strings.ToLower(message)
+	switch {
+	case isContentFilterMessage(lower):
+		err.Kind = ErrorContentFiltered
+	case status == http.StatusRequestEntityTooLarge || isContextLengthMessage(lower):
+		err.Kind = ErrorContextLength
+	case status == http.StatusTooManyRequests || (status == 0 && strings.Contains(lower, "rate_limit")):
+		err.Kind = ErrorRateLimited
+	case status == http.StatusUnauthorized || status == http.StatusForbidden ||
+		(status == 0 && (strings.Contains(lower, "authentication") || strings.Contains(lower, "permission") || strings.Contains(lower, "invalid_api_key"))):
+		err.Kind = ErrorAuth
+	case status == http.StatusNotFound || (status == 0 && strings.Contains(lower, "not_found")):
+		err.Kind = ErrorNotFound
+	case status == http.StatusBadRequest || status == http.StatusUnprocessableEntity ||
+		(status == 0 && strings.Contains(lower, "invalid_request")):
+		err.Kind = ErrorInvalidRequest
+	default:
+		err.Kind = ErrorRetryable
+	}
+	return err
+}
+
+// isContextLengthMessage распознает ошибку длины контекста в сообщениях
+// OpenAI, OpenRouter, Anthropic и Ollama
+func isContextLengthMessage(message string) bool {
+	for _, marker := range []string{"context_length_exceeded", "context length", "context window", "prompt is too long", "too many tokens"} {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// isContentFilterMessage распознает отказ модерации в сообщениях OpenAI,
+// Azure OpenAI и OpenRouter
+func isContentFilterMessage(message string) bool {
+	for _, marker := range []string{"content_filter", "content_policy", "content management policy", "flagged for moderation"} {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter разбирает Retry-After: число секунд или HTTP дату
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// errorKind возвращает класс ошибки. Ошибки без ответа API (сеть,
+// таймаут HTTP клиента, неполный ответ) считаются временными.
+func errorKind(err error) ErrorKind {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Kind
+	}
+	return ErrorRetryable
+}
+
+// retryable сообщает, стоит ли повторить запрос к тому же провайдеру
+func (k ErrorKind) retryable() bool {
+	return k == ErrorRetryable || k == ErrorRateLimited
+}
+
+// final сообщает, что другой провайдер ответит так же и переходить к
+// нему не нужно
+func (k ErrorKind) final() bool {
+	return k == ErrorInvalidRequest || k == ErrorContentFiltered
+}
+
+// countsAsFailure сообщает, говорит ли ошибка о неисправности провайдера.
+// Ошибки в запросе circuit breaker не учитывает.
+func (k ErrorKind) countsAsFailure() bool {
+	return k == ErrorRetryable || k == ErrorRateLimited || k == ErrorAuth || k == ErrorNotFound
+}
+
+// readBody читает ответ API, но не больше maxResponseSize: ошибочный
+// или чужой ответ (например, прокси) не должен занять всю память
+func readBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseSize {
+		return nil, fmt.Errorf("response exceeds %d bytes", maxResponseSize)
+	}
+	return body, nil
+}
+
+// upstreamRequestID возвращает идентификатор запроса из заголовков ответа:
+// x-request-id у OpenAI и совместимых API, request-id у Anthropic, cf-ray у
+// API за Cloudflare (OpenRouter)
+func upstreamRequestID(header http.Header) string {
+	for _, name := range []string{"X-Request-Id", "Request-Id", "Cf-Ray"} {
+		if id := header.Get(name); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// errorBody возвращает начало тела ответа с ошибкой, если API не прислал
+// ошибку в своем формате (например, страницу прокси)
+func errorBody(body []byte) string {
+	text := strings.TrimSpace(string(body))
+	if len(text) > 200 {
+		text = text[:200] + "..."
+	}
+	if text == "" {
+		return "empty response"
+	}
+	return text
+}
+
+// retryDelay возвращает паузу перед повтором номер retry (с нуля):
+// Retry-After провайдера или экспоненциальную паузу со случайным
+// разбросом. false - ждать дольше retryAfterLimit не стоит.
+func retryDelay(retry int, err error) (time.Duration, bool) {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) && providerErr.RetryAfter > 0 {
+		return providerErr.RetryAfter, providerErr.RetryAfter <= retryAfterLimit
+	}
+
+	delay := retryBaseDelay << retry
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	// Разброс от половины до полной паузы, чтобы повторы разных
+	// запросов не приходили к провайдеру одновременно
+	return delay/2 + rand.N(delay/2+1), true
+}
+
+// waitRetry ждет паузу перед повтором. Возвращает false, если пауза не
+// укладывается в срок запроса или запрос отменен.
+func waitRetry(ctx context.Context, delay time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Состояния circuit breaker
+const (
+	CircuitClosed   = "closed"    // Запросы идут к провайдеру
+	CircuitOpen     = "open"      // Провайдер пропускается до OpenUntil
+	CircuitHalfOpen = "half-open" // Пробный запрос после паузы
+)
+
+// CircuitState состояние circuit breaker провайдера
+type CircuitState struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"` // Ошибок подряд
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+}
+
+// circuitBreaker временно отключает провайдера после threshold ошибок
+// подряд. По истечении cooldown пропускает один пробный запрос: успех
+// возвращает провайдера, ошибка отключает его снова.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool // Пробный запрос уже выполняется
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow сообщает, можно ли отправить запрос провайдеру
+func (b *circuitBreaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record учитывает результат запроса, пропущенного allow. Для отмененного
+// запроса передается ctx.Err(): отмена не говорит о состоянии провайдера.
+func (b *circuitBreaker) record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	switch {
+	case err == nil:
+		b.failures = 0
+	case err == context.Canceled || err == context.DeadlineExceeded || !errorKind(err).countsAsFailure():
+		// Ошибки в самом запросе тоже не учитываются
+	default:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.openUntil = time.Now().Add(b.cooldown)
+		}
+	}
+}
+
+// state возвращает состояние для админки
+func (b *circuitBreaker) state() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := CircuitState{State: CircuitClosed, Failures: b.failures}
+	if b.threshold > 0 && b.failures >= b.threshold {
+		if until := b.openUntil; time.Now().Before(until) {
+			state.State = CircuitOpen
+			state.OpenUntil = &until
+		} else {
+			state.State = CircuitHalfOpen
+		}
+	}
+	return state
+}
+
+// circuitOpenError ошибка для провайдера, пропущенного circuit breaker
+func circuitOpenError(provider string) error {
+	return fmt.Errorf("%w: %s temporarily disabled after repeated failures", ErrNoProvider, provider)
+}
+
+// attempt выполняет запрос к провайдеру, повторяя временные ошибки с
+// паузой, пока повтор укладывается в срок запроса. call возвращает, успел
+// ли провайдер передать часть ответа: такой запрос не повторяется.
+func (c *Client) attempt(ctx context.Context, provider Provider, call func() (*ChatResponse, bool, error)) (*ChatResponse, bool, error) {
+	breaker := c.breakers[provider.Name()]
+	for retry := 0; ; retry++ {
+		response, streamed, err := call()
+		if ctx.Err() != nil {
+			breaker.record(ctx.Err())
+			return response, streamed, err
+		}
+		if err == nil || streamed || retry >= c.config.Retries || !errorKind(err).retryable() {
+			breaker.record(err)
+			return response, streamed, err
+		}
+
+		delay, ok := retryDelay(retry, err)
+		if !ok || !waitRetry(ctx, delay) {
+			breaker.record(err)
+			return response, streamed, err
+		}
+		fmt.Printf("%s failed, retrying in %v: %v\n", provider.Name(), delay.Round(time.Millisecond), err)
+	}
+}
+
+// Circuits возвращает состояние circuit breaker каждого провайдера
+func (c *Client) Circuits() map[string]CircuitState {
+	states := make(map[string]CircuitState, len(c.breakers))
+	for name, breaker := range c.breakers {
+		states[name] = breaker.state()
+	}
+	return states
+}
@@ -0,0 +1,268 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProvider провайдер для тестов клиента: отвечает ошибками из errs по
+// очереди, затем успешно. Ответ приходит через delay и частями deltas.
+type fakeProvider struct {
+	name   string
+	errs   []error
+	delay  time.Duration
+	deltas []string
+
+	mu        sync.Mutex
+	calls     int
+	cancelled int // Запросов, прерванных отменой контекста
+}
+
+func (p *fakeProvider) Name() string  { return p.name }
+func (p *fakeProvider) Model() string { return p.name + "-model" }
+
+func (p *fakeProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	return p.ChatStream(ctx, req, func(string) error { return nil })
+}
+
+func (p *fakeProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (*ChatResponse, error) {
+	p.mu.Lock()
+	call := p.calls
+	p.calls++
+	p.mu.Unlock()
+
+	if call < len(p.errs) && p.errs[call] != nil {
+		return nil, p.errs[call]
+	}
+	deltas := p.deltas
+	if len(deltas) == 0 {
+		deltas = []string{"ответ " + p.name}
+	}
+	response := &ChatResponse{Model: p.Model(), FinishReason: "stop"}
+	for _, delta := range deltas {
+		select {
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.cancelled++
+			p.mu.Unlock()
+			return response, ctx.Err()
+		case <-time.After(p.delay):
+		}
+		response.Content += delta
+		if err := onDelta(delta); err != nil {
+			return response, err
+		}
+	}
+	return response, nil
+}
+
+func (p *fakeProvider) stats() (calls, cancelled int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls, p.cancelled
+}
+
+// newTestClient собирает клиент из провайдеров без обращения к реестру
+func newTestClient(retries, threshold int, providers ...Provider) *Client {
+	catalogs := make(map[string]*modelCatalog, len(providers))
+	breakers := make(map[string]*circuitBreaker, len(providers))
+	for _, provider := range providers {
+		catalogs[provider.Name()] = &modelCatalog{}
+		breakers[provider.Name()] = newCircuitBreaker(threshold, 50*time.Millisecond)
+	}
+	return &Client{
+		config:    &Config{Retries: retries},
+		providers: providers,
+		catalogs:  catalogs,
+		breakers:  breakers,
+	}
+}
+
+// apiError ошибка API с коротким Retry-After, чтобы повторы в тестах не ждали
+func apiError(status int, message string) *ProviderError {
+	return newAPIError("fake", status, http.Header{"Retry-After": {"0.001"}}, message)
+}
+
+func TestErrorClassification(t *testing.T) {
+	tests := []struct {
+		status  int
+		message string
+		kind    ErrorKind
+		retry   bool
+		final   bool
+		failure bool
+	}{
+		{http.StatusInternalServerError, "internal error", ErrorRetryable, true, false, true},
+		{http.StatusBadGateway, "bad gateway", ErrorRetryable, true, false, true},
+		{http.StatusTooManyRequests, "slow down", ErrorRateLimited, true, false, true},
+		{http.StatusUnauthorized, "invalid key", ErrorAuth, false, false, true},
+		{http.StatusForbidden, "forbidden", ErrorAuth, false, false, true},
+		{http.StatusNotFound, "no such model", ErrorNotFound, false, false, true},
+		{http.StatusBadRequest, "invalid temperature", ErrorInvalidRequest, false, true, false},
+		{http.StatusBadRequest, "This model's maximum context length is 8192 tokens (context_length_exceeded)", ErrorContextLength, false, false, false},
+		{http.StatusRequestEntityTooLarge, "request too large", ErrorContextLength, false, false, false},
+		{http.StatusBadRequest, "flagged for moderation: violence", ErrorContentFiltered, false, true, false},
+		{0, "rate_limit_error: slow down", ErrorRateLimited, true, false, true},
+		{0, "overloaded_error: Overloaded", ErrorRetryable, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			kind := errorKind(newAPIError("fake", tt.status, nil, tt.message))
+			if kind != tt.kind {
+				t.Fatalf("kind = %v, want %v", kind, tt.kind)
+			}
+			if kind.retryable() != tt.retry || kind.final() != tt.final || kind.countsAsFailure() != tt.failure {
+				t.Errorf("%v: retryable %v, final %v, failure %v", kind, kind.retryable(), kind.final(), kind.countsAsFailure())
+			}
+		})
+	}
+
+	if kind := errorKind(errors.New("connection reset")); kind != ErrorRetryable {
+		t.Errorf("network error kind = %v, want retryable", kind)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for retry := range 6 {
+		delay, ok := retryDelay(retry, errors.New("timeout"))
+		full := min(retryBaseDelay<<retry, retryMaxDelay)
+		if !ok || delay < full/2 || delay > full {
+			t.Errorf("retry %d: delay %v, want %v..%v", retry, delay, full/2, full)
+		}
+	}
+
+	header := http.Header{"Retry-After": {"3"}}
+	if delay, ok := retryDelay(0, newAPIError("fake", 429, header, "")); !ok || delay != 3*time.Second {
+		t.Errorf("Retry-After 3: delay %v, %v", delay, ok)
+	}
+	header.Set("Retry-After", "3600")
+	if _, ok := retryDelay(0, newAPIError("fake", 429, header, "")); ok {
+		t.Error("Retry-After 3600 should not be waited for")
+	}
+}
+
+func TestAttemptRetries(t *testing.T) {
+	unavailable := apiError(http.StatusServiceUnavailable, "unavailable")
+	tests := []struct {
+		name    string
+		retries int
+		errs    []error
+		calls   int
+		failed  bool
+	}{
+		{"success", 2, nil, 1, false},
+		{"retried until success", 2, []error{unavailable, unavailable}, 3, false},
+		{"retries exhausted", 2, []error{unavailable, unavailable, unavailable, unavailable}, 3, true},
+		{"no retries", 0, []error{unavailable}, 1, true},
+		{"rate limit is retried", 1, []error{apiError(http.StatusTooManyRequests, "slow down")}, 2, false},
+		{"invalid request is not retried", 2, []error{apiError(http.StatusBadRequest, "bad")}, 1, true},
+		{"auth error is not retried", 2, []error{apiError(http.StatusUnauthorized, "bad key")}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeProvider{name: "fake", errs: tt.errs}
+			client := newTestClient(tt.retries, 0, provider)
+			_, err := client.Chat(context.Background(), []ChatMessage{{Role: "user", Content: "Привет"}})
+			if (err != nil) != tt.failed {
+				t.Errorf("error = %v, failed %v", err, tt.failed)
+			}
+			if calls, _ := provider.stats(); calls != tt.calls {
+				t.Errorf("calls = %d, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestFallback(t *testing.T) {
+	primary := &fakeProvider{name: "primary", errs: []error{apiError(http.StatusUnauthorized, "bad key")}}
+	second := &fakeProvider{name: "second"}
+	result, err := newTestClient(0, 0, primary, second).Chat(context.Background(), []ChatMessage{{Role: "user", Content: "Привет"}})
+	if err != nil || result.Provider != "second" {
+		t.Fatalf("result = %+v, %v; want an answer from second", result, err)
+	}
+
+	// Ошибка в запросе не исправится у другого провайдера
+	primary = &fakeProvider{name: "primary", errs: []error{apiError(http.StatusBadRequest, "bad")}}
+	second = &fakeProvider{name: "second"}
+	if _, err := newTestClient(0, 0, primary, second).Chat(context.Background(), []ChatMessage{{Role: "user", Content: "Привет"}}); err == nil {
+		t.Fatal("invalid request fell back to the second provider")
+	}
+	if calls, _ := second.stats(); calls != 0 {
+		t.Errorf("second provider calls = %d, want 0", calls)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	failure := apiError(http.StatusServiceUnavailable, "unavailable")
+	b := newCircuitBreaker(2, 50*time.Millisecond)
+
+	b.record(failure)
+	if !b.allow() || b.state().State != CircuitClosed {
+		t.Fatalf("opened after one failure: %+v", b.state())
+	}
+	// Ошибки в запросе и отмена не говорят о неисправности провайдера
+	b.record(apiError(http.StatusBadRequest, "bad"))
+	b.record(context.Canceled)
+	if b.state().Failures != 1 {
+		t.Fatalf("failures = %d, want 1", b.state().Failures)
+	}
+
+	b.record(failure)
+	if b.allow() || b.state().State != CircuitOpen {
+		t.Fatalf("not open after threshold: %+v", b.state())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if state := b.state().State; state != CircuitHalfOpen {
+		t.Fatalf("state = %s, want half-open", state)
+	}
+	if !b.allow() {
+		t.Fatal("probe request is not allowed")
+	}
+	if b.allow() {
+		t.Fatal("second request allowed while probing")
+	}
+	b.record(failure)
+	if b.allow() || b.state().State != CircuitOpen {
+		t.Fatalf("failed probe did not reopen: %+v", b.state())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("probe request is not allowed")
+	}
+	b.record(nil)
+	if state := b.state(); state.State != CircuitClosed || state.Failures != 0 || !b.allow() {
+		t.Fatalf("successful probe did not close: %+v", state)
+	}
+}
+
+func TestCircuitBreakerSkipsProvider(t *testing.T) {
+	failure := apiError(http.StatusServiceUnavailable, "unavailable")
+	primary := &fakeProvider{name: "primary", errs: []error{failure, failure}}
+	second := &fakeProvider{name: "second"}
+	client := newTestClient(0, 2, primary, second)
+	messages := []ChatMessage{{Role: "user", Content: "Привет"}}
+
+	for range 3 {
+		if _, err := client.Chat(context.Background(), messages); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls, _ := primary.stats(); calls != 2 {
+		t.Errorf("primary calls = %d, want 2: the third request must skip it", calls)
+	}
+
+	// Без запасного провайдера ошибка сводится к ErrNoProvider
+	only := *client
+	only.providers = client.providers[:1]
+	_, err := only.Chat(context.Background(), messages)
+	if !errors.Is(err, ErrNoProvider) || !strings.Contains(err.Error(), "primary") {
+		t.Errorf("error = %v, want ErrNoProvider", err)
+	}
+}
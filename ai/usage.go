@@ -0,0 +1,100 @@
+package ai
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// ChatResult результат запроса к AI с расходом токенов
+type ChatResult struct {
+	Content      string
+	Usage        Usage
+	Estimated    bool          // Провайдер не сообщил расход, токены оценены по тексту
+	Cost         float64       // Оценка стоимости в USD, 0 если цены модели неизвестны
+	Model        string        // Модель, фактически обработавшая запрос
+	Provider     string        // Провайдер, ответивший на запрос
+	FinishReason string        // Причина завершения ответа
+	Latency      time.Duration // Время от начала запроса, включая переходы к fallback
+	RequestID    string        // Идентификатор запроса у провайдера
+}
+
+// Cost оценивает стоимость запроса по ценам модели (USD за токен, как в
+// списке моделей OpenRouter). Возвращает false, если цены неизвестны.
+func (m ModelInfo) Cost(usage Usage) (float64, bool) {
+	if m.Pricing == nil {
+		return 0, false
+	}
+	prompt, ok := parsePrice(m.Pricing.Prompt)
+	if !ok {
+		return 0, false
+	}
+	completion, ok := parsePrice(m.Pricing.Completion)
+	if !ok {
+		return 0, false
+	}
+	return float64(usage.PromptTokens)*prompt + float64(usage.CompletionTokens)*completion, true
+}
+
+// parsePrice разбирает цену: OpenRouter передает ее строкой ("0.000003"),
+// другие совместимые API - числом
+func parsePrice(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case string:
+		price, err := strconv.ParseFloat(v, 64)
+		return price, err == nil && price >= 0
+	case float64:
+		return v, v >= 0
+	}
+	return 0, false
+}
+
+// SetUsageHook задает функцию, вызываемую после каждого успешного запроса
+// к провайдеру, включая служебные (краткое содержание разговора).
+// Задается до начала обработки запросов.
+func (c *Client) SetUsageHook(hook func(*ChatResult)) {
+	c.usageHook = hook
+}
+
+// newResult формирует результат запроса: оценивает расход, если провайдер
+// его не сообщил, считает стоимость и передает результат в usageHook
+func (c *Client) newResult(ctx context.Context, provider Provider, messages []ChatMessage, response *ChatResponse, start time.Time) *ChatResult {
+	result := &ChatResult{
+		Content:      response.Content,
+		Usage:        response.Usage,
+		Model:        response.Model,
+		Provider:     provider.Name(),
+		FinishReason: response.FinishReason,
+		Latency:      time.Since(start),
+		RequestID:    response.RequestID,
+	}
+	if result.Model == "" {
+		result.Model = provider.Model()
+	}
+
+	if result.Usage.PromptTokens == 0 && result.Usage.CompletionTokens == 0 {
+		result.Usage.PromptTokens = EstimateMessagesTokens(messages)
+		result.Usage.CompletionTokens = EstimateTokens(response.Content)
+		result.Estimated = true
+	}
+	if result.Usage.TotalTokens == 0 {
+		result.Usage.TotalTokens = result.Usage.PromptTokens + result.Usage.CompletionTokens
+	}
+
+	// Цену ищем в списке моделей ответившего провайдера. Он может вернуть
+	// полное имя модели с датой версии, поэтому при неудаче ищем по имени
+	// из конфигурации
+	for _, id := range []string{result.Model, provider.Model()} {
+		if model, ok := c.lookupModel(ctx, provider, id); ok {
+			if cost, ok := model.Cost(result.Usage); ok {
+				result.Cost = cost
+				break
+			}
+		}
+	}
+
+	if c.usageHook != nil {
+		c.usageHook(result)
+	}
+	return result
+}
@@ -0,0 +1,78 @@
+package ai
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// listingProvider fakeProvider со списком моделей
+type listingProvider struct {
+	*fakeProvider
+	models []ModelInfo
+	hold   chan struct{} // Если задан, список отдается только после его закрытия
+
+	listMu sync.Mutex
+	lists  int // Сколько раз запрашивался список
+}
+
+func (p *listingProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	p.listMu.Lock()
+	p.lists++
+	p.listMu.Unlock()
+	if p.hold != nil {
+		<-p.hold
+	}
+	return p.models, nil
+}
+
+func (p *listingProvider) listCount() int {
+	p.listMu.Lock()
+	defer p.listMu.Unlock()
+	return p.lists
+}
+
+// priced модель с ценой за токен
+func priced(id string, price string) ModelInfo {
+	model := ModelInfo{ID: id, ContextLength: 100000}
+	model.Pricing = &struct {
+		Prompt     interface{} `json:"prompt"`
+		Completion interface{} `json:"completion"`
+	}{price, price}
+	return model
+}
+
+func TestCostUsesAnsweringProviderCatalog(t *testing.T) {
+	openrouter := &listingProvider{
+		fakeProvider: &fakeProvider{name: "openrouter"},
+		models:       []ModelInfo{priced("openrouter-model", "0.001")},
+	}
+	anthropic := &listingProvider{
+		fakeProvider: &fakeProvider{name: "anthropic"},
+		models:       []ModelInfo{{ID: "anthropic-model"}},
+	}
+	client := newTestClient(0, 0, openrouter, anthropic)
+	site, ok := client.WithProvider("anthropic")
+	if !ok {
+		t.Fatal("WithProvider(anthropic) = false")
+	}
+	messages := []ChatMessage{{Role: "user", Content: "привет"}}
+
+	// Первым список моделей загружает копия сайта со своим провайдером
+	if _, err := site.Chat(context.Background(), messages); err != nil {
+		t.Fatalf("site Chat() = %v", err)
+	}
+	result, err := client.Chat(context.Background(), messages)
+	if err != nil {
+		t.Fatalf("Chat() = %v", err)
+	}
+	if result.Cost <= 0 {
+		t.Errorf("Cost = %v, want the openrouter price", result.Cost)
+	}
+	if model, ok := client.LookupModel(context.Background(), "openrouter-model"); !ok || model.ContextLength != 100000 {
+		t.Errorf("LookupModel(openrouter-model) = %+v, %v", model, ok)
+	}
+	if _, ok := site.LookupModel(context.Background(), "openrouter-model"); ok {
+		t.Error("site LookupModel found a model of another provider")
+	}
+}
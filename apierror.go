@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"ai-bot/ai"
+)
+
+// Коды ошибок /api/chat. Коды стабильны: по ним виджет и интеграции
+// выбирают, что показать пользователю.
+const (
+	codeInvalidRequest  = "invalid_request"
+	codeUnknownSite     = "unknown_site"
+	codeOriginDenied    = "origin_not_allowed"
+	codeRateLimited     = "rate_limited"
+	codeContextTooLong  = "context_too_long"
+	codeAuth            = "auth"
+	codeContentFiltered = "content_filtered"
+	codeTimeout         = "timeout"
+	codeNoProvider      = "no_provider"
+	codeProviderError   = "provider_error"
+	codeInternal        = "internal"
+)
+
+// chatError тело ответа с ошибкой: код, сообщение без подробностей
+// провайдера и идентификатор запроса, по которому ошибку можно найти в логе
+type chatError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+
+	status     int
+	retryAfter int // Секунды для заголовка Retry-After, 0 - не задан
+}
+
+// newRequestID возвращает идентификатор запроса для ответа и лога
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// responseRequestID возвращает идентификатор запроса из заголовка
+// X-Request-ID ответа, а если его еще нет - создает и записывает в заголовок.
+// Так middleware и обработчик отвечают с одним идентификатором.
+func responseRequestID(w http.ResponseWriter) string {
+	if id := w.Header().Get("X-Request-ID"); id != "" {
+		return id
+	}
+	id := newRequestID()
+	w.Header().Set("X-Request-ID", id)
+	return id
+}
+
+// aiError сводит ошибку AI клиента к ответу пользователю
+func aiError(err error) chatError {
+	switch {
+	case errors.Is(err, ai.ErrContentFiltered):
+		return chatError{Code: codeContentFiltered, Message: "Запрос отклонен фильтром содержимого. Попробуйте сформулировать его иначе.", status: http.StatusUnprocessableEntity}
+	case errors.Is(err, ai.ErrContextTooLong):
+		return chatError{Code: codeContextTooLong, Message: "Сообщение слишком длинное. Сократите его и отправьте еще раз.", status: http.StatusRequestEntityTooLarge}
+	case errors.Is(err, ai.ErrTimeout):
+		return chatError{Code: codeTimeout, Message: "Ассистент не успел ответить. Попробуйте еще раз.", status: http.StatusGatewayTimeout}
+	case errors.Is(err, ai.ErrRateLimited):
+		e := chatError{Code: codeRateLimited, Message: "Ассистент перегружен. Попробуйте через минуту.", status: http.StatusTooManyRequests}
+		var providerErr *ai.ProviderError
+		if errors.As(err, &providerErr) && providerErr.RetryAfter > 0 {
+			e.retryAfter = int(math.Ceil(providerErr.RetryAfter.Seconds()))
+		}
+		return e
+	case errors.Is(err, ai.ErrAuth):
+		return chatError{Code: codeAuth, Message: "Ассистент временно недоступен. Попробуйте позже.", status: http.StatusBadGateway}
+	case errors.Is(err, ai.ErrNoProvider):
+		return chatError{Code: codeNoProvider, Message: "Ассистент временно недоступен. Попробуйте позже.", status: http.StatusServiceUnavailable}
+	}
+	return chatError{Code: codeProviderError, Message: "Не удалось получить ответ. Попробуйте еще раз.", status: http.StatusBadGateway}
+}
+
+// writeChatError отвечает ошибкой в формате JSON
+func writeChatError(w http.ResponseWriter, requestID string, e chatError) {
+	e.RequestID = requestID
+	if e.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(e.retryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// respondAIError записывает ошибку AI в лог с идентификатором запроса и
+// отвечает пользователю безопасным сообщением: JSON или событием error потока
+func respondAIError(w http.ResponseWriter, requestID string, events *eventStream, err error) {
+	e := aiError(err)
+	log.Printf("Ошибка AI (%s), запрос %s: %v", e.Code, requestID, err)
+	if events != nil {
+		e.RequestID = requestID
+		events.Send("error", e)
+		return
+	}
+	writeChatError(w, requestID, e)
+}
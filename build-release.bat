@@ -24,6 +24,8 @@ if %errorlevel% neq 0 goto :error
 copy README.md temp\ai-bot-windows-amd64\ >nul 2>&1
 copy USAGE.md temp\ai-bot-windows-amd64\ >nul 2>&1
 copy .env.example temp\ai-bot-windows-amd64\ >nul 2>&1
+copy prompts.example.yaml temp\ai-bot-windows-amd64\ >nul 2>&1
+copy config.example.yaml temp\ai-bot-windows-amd64\ >nul 2>&1
 
 REM Создаем install.bat
 echo @echo off > temp\ai-bot-windows-amd64\install.bat
@@ -52,6 +54,8 @@ if %errorlevel% neq 0 goto :error
 copy README.md temp\ai-bot-windows-386\ >nul 2>&1
 copy USAGE.md temp\ai-bot-windows-386\ >nul 2>&1
 copy .env.example temp\ai-bot-windows-386\ >nul 2>&1
+copy prompts.example.yaml temp\ai-bot-windows-386\ >nul 2>&1
+copy config.example.yaml temp\ai-bot-windows-386\ >nul 2>&1
 copy temp\ai-bot-windows-amd64\install.bat temp\ai-bot-windows-386\ >nul
 
 powershell -Command "Compress-Archive -Path 'temp\ai-bot-windows-386\*' -DestinationPath 'releases\ai-bot-windows-386.zip' -Force"
@@ -71,6 +75,8 @@ if %errorlevel% neq 0 goto :error
 copy README.md temp\ai-bot-linux-amd64\ >nul 2>&1
 copy USAGE.md temp\ai-bot-linux-amd64\ >nul 2>&1
 copy .env.example temp\ai-bot-linux-amd64\ >nul 2>&1
+copy prompts.example.yaml temp\ai-bot-linux-amd64\ >nul 2>&1
+copy config.example.yaml temp\ai-bot-linux-amd64\ >nul 2>&1
 
 REM Создаем install.sh
 echo #!/bin/bash > temp\ai-bot-linux-amd64\install.sh
@@ -103,6 +109,8 @@ if %errorlevel% neq 0 goto :error
 copy README.md temp\ai-bot-linux-386\ >nul 2>&1
 copy USAGE.md temp\ai-bot-linux-386\ >nul 2>&1
 copy .env.example temp\ai-bot-linux-386\ >nul 2>&1
+copy prompts.example.yaml temp\ai-bot-linux-386\ >nul 2>&1
+copy config.example.yaml temp\ai-bot-linux-386\ >nul 2>&1
 copy temp\ai-bot-linux-amd64\install.sh temp\ai-bot-linux-386\ >nul
 
 powershell -Command "Compress-Archive -Path 'temp\ai-bot-linux-386\*' -DestinationPath 'releases\ai-bot-linux-386.zip' -Force"
@@ -119,6 +127,8 @@ if %errorlevel% neq 0 goto :error
 copy README.md temp\ai-bot-linux-arm64\ >nul 2>&1
 copy USAGE.md temp\ai-bot-linux-arm64\ >nul 2>&1
 copy .env.example temp\ai-bot-linux-arm64\ >nul 2>&1
+copy prompts.example.yaml temp\ai-bot-linux-arm64\ >nul 2>&1
+copy config.example.yaml temp\ai-bot-linux-arm64\ >nul 2>&1
 copy temp\ai-bot-linux-amd64\install.sh temp\ai-bot-linux-arm64\ >nul
 
 powershell -Command "Compress-Archive -Path 'temp\ai-bot-linux-arm64\*' -DestinationPath 'releases\ai-bot-linux-arm64.zip' -Force"
@@ -138,6 +148,8 @@ if %errorlevel% neq 0 goto :error
 copy README.md temp\ai-bot-freebsd-amd64\ >nul 2>&1
 copy USAGE.md temp\ai-bot-freebsd-amd64\ >nul 2>&1
 copy .env.example temp\ai-bot-freebsd-amd64\ >nul 2>&1
+copy prompts.example.yaml temp\ai-bot-freebsd-amd64\ >nul 2>&1
+copy config.example.yaml temp\ai-bot-freebsd-amd64\ >nul 2>&1
 copy temp\ai-bot-linux-amd64\install.sh temp\ai-bot-freebsd-amd64\ >nul
 
 powershell -Command "Compress-Archive -Path 'temp\ai-bot-freebsd-amd64\*' -DestinationPath 'releases\ai-bot-freebsd-amd64.zip' -Force"
@@ -154,6 +166,8 @@ if %errorlevel% neq 0 goto :error
 copy README.md temp\ai-bot-freebsd-386\ >nul 2>&1
 copy USAGE.md temp\ai-bot-freebsd-386\ >nul 2>&1
 copy .env.example temp\ai-bot-freebsd-386\ >nul 2>&1
+copy prompts.example.yaml temp\ai-bot-freebsd-386\ >nul 2>&1
+copy config.example.yaml temp\ai-bot-freebsd-386\ >nul 2>&1
 copy temp\ai-bot-linux-amd64\install.sh temp\ai-bot-freebsd-386\ >nul
 
 powershell -Command "Compress-Archive -Path 'temp\ai-bot-freebsd-386\*' -DestinationPath 'releases\ai-bot-freebsd-386.zip' -Force"
@@ -173,6 +187,8 @@ if %errorlevel% neq 0 goto :error
 copy README.md temp\ai-bot-darwin-amd64\ >nul 2>&1
 copy USAGE.md temp\ai-bot-darwin-amd64\ >nul 2>&1
 copy .env.example temp\ai-bot-darwin-amd64\ >nul 2>&1
+copy prompts.example.yaml temp\ai-bot-darwin-amd64\ >nul 2>&1
+copy config.example.yaml temp\ai-bot-darwin-amd64\ >nul 2>&1
 copy temp\ai-bot-linux-amd64\install.sh temp\ai-bot-darwin-amd64\ >nul
 
 powershell -Command "Compress-Archive -Path 'temp\ai-bot-darwin-amd64\*' -DestinationPath 'releases\ai-bot-darwin-amd64.zip' -Force"
@@ -189,6 +205,8 @@ if %errorlevel% neq 0 goto :error
 copy README.md temp\ai-bot-darwin-arm64\ >nul 2>&1
 copy USAGE.md temp\ai-bot-darwin-arm64\ >nul 2>&1
 copy .env.example temp\ai-bot-darwin-arm64\ >nul 2>&1
+copy prompts.example.yaml temp\ai-bot-darwin-arm64\ >nul 2>&1
+copy config.example.yaml temp\ai-bot-darwin-arm64\ >nul 2>&1
 copy temp\ai-bot-linux-amd64\install.sh temp\ai-bot-darwin-arm64\ >nul
 
 powershell -Command "Compress-Archive -Path 'temp\ai-bot-darwin-arm64\*' -DestinationPath 'releases\ai-bot-darwin-arm64.zip' -Force"
@@ -56,6 +56,8 @@ foreach ($platform in $platforms) {
         Copy-Item "README.md" "$tempDir\" -ErrorAction SilentlyContinue
         Copy-Item "USAGE.md" "$tempDir\" -ErrorAction SilentlyContinue
         Copy-Item ".env.example" "$tempDir\" -ErrorAction SilentlyContinue
+        Copy-Item "prompts.example.yaml" "$tempDir\" -ErrorAction SilentlyContinue
+        Copy-Item "config.example.yaml" "$tempDir\" -ErrorAction SilentlyContinue
         
         # Создаем install скрипт для Unix систем
         if ($platform.OS -ne "windows") {
@@ -196,6 +198,8 @@ sha256sum -c checksums.txt
 - **README.md** - Основная документация
 - **USAGE.md** - Руководство по использованию
 - **.env.example** - Пример конфигурации
+- **prompts.example.yaml** - Пример пресетов промптов
+- **config.example.yaml** - Пример файла конфигурации (--config-file)
 
 ## 🆘 Поддержка:
 
@@ -1,14 +1,69 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+
+	"ai-bot/config"
 )
 
-func serveEmbeddedChatNew(w http.ResponseWriter, r *http.Request) {
+// Приветствие и быстрые кнопки виджета для сайтов без своих настроек
+const defaultWelcome = "Привет! Я помогу вам с любыми вопросами. Просто напишите что вас интересует."
+
+var defaultQuickButtons = []config.QuickButton{
+	{Label: "👋 Привет", Message: "Как дела?"},
+	{Label: "💻 Код", Message: "Помоги с кодом"},
+	{Label: "📚 Обучение", Message: "Объясни концепцию"},
+}
+
+// widgetSettings настройки сайта, встраиваемые в код виджета
+type widgetSettings struct {
+	Key          string               `json:"key"`
+	Welcome      string               `json:"welcome"`
+	QuickButtons []config.QuickButton `json:"quickButtons"`
+	Colors       struct {
+		Primary   string `json:"primary"`
+		Secondary string `json:"secondary"`
+		Accent    string `json:"accent"`
+	} `json:"colors"`
+}
+
+func newWidgetSettings(site *site) widgetSettings {
+	settings := widgetSettings{
+		Key:          site.Key,
+		Welcome:      site.Welcome,
+		QuickButtons: site.QuickButtons,
+	}
+	if settings.Welcome == "" {
+		settings.Welcome = defaultWelcome
+	}
+	if len(settings.QuickButtons) == 0 {
+		settings.QuickButtons = defaultQuickButtons
+	}
+	settings.Colors.Primary = orDefault(site.PrimaryColor, "#667eea")
+	settings.Colors.Secondary = orDefault(site.SecondaryColor, "#764ba2")
+	settings.Colors.Accent = orDefault(site.AccentColor, "#ff4757")
+	return settings
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func serveEmbeddedChatNew(w http.ResponseWriter, r *http.Request, sites *siteRegistry) {
 	w.Header().Set("Content-Type", "application/javascript")
 	w.Header().Set("Cache-Control", "no-cache")
 
+	site, ok := sites.Lookup(r.URL.Query().Get("site_key"))
+	if !ok {
+		fmt.Fprint(w, "console.warn('AI chat: неизвестный ключ сайта');\n")
+		return
+	}
+
 	// Получаем базовый URL для API
 	scheme := "http"
 	if r.TLS != nil {
@@ -16,22 +71,65 @@ func serveEmbeddedChatNew(w http.ResponseWriter, r *http.Request) {
 	}
 	baseURL := fmt.Sprintf("%s://%s", scheme, r.Host)
 
+	// Список разрешенных сайтов: виджет не запускается на остальных
+	allowedOrigins, _ := json.Marshal(site.origins.Patterns())
+	settings, _ := json.Marshal(newWidgetSettings(site))
+
 	// Создаем JavaScript код
 	js := `(function() {
-	// Получаем кастомные CSS из data-атрибутов скрипта
+	var baseURL = '` + baseURL + `';
+	var site = ` + string(settings) + `;
 	var scriptTag = document.currentScript || document.querySelector('script[src*="chat.js"]');
+
+	// Ключ сайта из data-site-key: перезагружаем виджет с настройками этого сайта
+	var siteKey = scriptTag ? scriptTag.getAttribute('data-site-key') || '' : '';
+	if (siteKey && siteKey !== site.key) {
+		var siteScript = document.createElement('script');
+		Array.prototype.forEach.call(scriptTag.attributes, function(attr) {
+			if (attr.name.indexOf('data-') === 0) {
+				siteScript.setAttribute(attr.name, attr.value);
+			}
+		});
+		siteScript.src = baseURL + '/chat.js?site_key=' + encodeURIComponent(siteKey);
+		scriptTag.parentNode.insertBefore(siteScript, scriptTag.nextSibling);
+		return;
+	}
+
+	// Виджет работает только на разрешенных сайтах
+	var allowedOrigins = ` + string(allowedOrigins) + `;
+	function originAllowed(origin) {
+		origin = origin.toLowerCase();
+		if (!allowedOrigins.length || origin === baseURL.toLowerCase()) return true;
+		return allowedOrigins.some(function(pattern) {
+			if (pattern === '*' || pattern === origin) return true;
+			var wildcard = pattern.indexOf('://*.');
+			if (wildcard === -1) return false;
+			var prefix = pattern.slice(0, wildcard + 3);
+			var suffix = pattern.slice(wildcard + 4);
+			return origin.indexOf(prefix) === 0 && origin.length > prefix.length + suffix.length &&
+				origin.slice(-suffix.length) === suffix;
+		});
+	}
+	if (!originAllowed(window.location.origin)) {
+		console.warn('AI chat: виджет не разрешен для ' + window.location.origin);
+		return;
+	}
+
+	// Получаем кастомные CSS из data-атрибутов скрипта, цвета по умолчанию - из настроек сайта
 	var customCSS = '';
-	var customColors = {};
+	var customColors = {primary: site.colors.primary, secondary: site.colors.secondary, accent: site.colors.accent};
 	
 	var systemPrompt = '';
+	var promptPreset = '';
 	
 	if (scriptTag) {
 		// Читаем data-атрибуты для кастомизации
-		customColors.primary = scriptTag.getAttribute('data-primary-color') || '#667eea';
-		customColors.secondary = scriptTag.getAttribute('data-secondary-color') || '#764ba2';
-		customColors.accent = scriptTag.getAttribute('data-accent-color') || '#ff4757';
+		customColors.primary = scriptTag.getAttribute('data-primary-color') || customColors.primary;
+		customColors.secondary = scriptTag.getAttribute('data-secondary-color') || customColors.secondary;
+		customColors.accent = scriptTag.getAttribute('data-accent-color') || customColors.accent;
 		customCSS = scriptTag.getAttribute('data-custom-css') || '';
 		systemPrompt = scriptTag.getAttribute('data-system-prompt') || '';
+		promptPreset = scriptTag.getAttribute('data-prompt-preset') || '';
 	}
 
 	// Базовые CSS стили для AI виджета
@@ -55,20 +153,38 @@ func serveEmbeddedChatNew(w http.ResponseWriter, r *http.Request) {
 	var badge = null;
 	var toggle = null;
 	var header = null;
-	var history = [];
+	var conversationId = null;
+	var conversationKey = 'aiChatConversationId' + (site.key ? '_' + site.key : '');
 	var isOpen = false;
 	var isTyping = false;
 	var isDragging = false;
 	var dragTarget = null;
 	var dragOffset = {x: 0, y: 0};
-	var apiUrl = '` + baseURL + `/api/chat';
+	var siteQuery = '?site_key=' + encodeURIComponent(site.key);
+	var streamUrl = baseURL + '/api/chat/stream' + siteQuery;
+	var conversationUrl = baseURL + '/api/conversation' + siteQuery;
+	var statusUrl = baseURL + '/api/status' + siteQuery;
+
+	// Сообщения для кодов ошибок сервера (поле code ответа /api/chat)
+	var errorMessages = {
+		rate_limited: 'Слишком много сообщений. Подождите немного и попробуйте снова.',
+		unknown_site: 'Чат не настроен для этого сайта.',
+		origin_not_allowed: 'Чат не настроен для этого сайта.',
+		context_too_long: 'Сообщение слишком длинное. Сократите его и отправьте еще раз.',
+		content_filtered: 'Не могу ответить на этот запрос. Попробуйте сформулировать его иначе.',
+		timeout: 'Ассистент не успел ответить. Попробуйте еще раз.',
+		auth: 'Ассистент временно недоступен. Попробуйте позже.',
+		no_provider: 'Ассистент временно недоступен. Попробуйте позже.',
+		invalid_request: 'Не удалось отправить сообщение. Проверьте текст и попробуйте еще раз.',
+		interrupted: 'Ответ прервался. Попробуйте еще раз.'
+	};
 	
 	function initChat() {
 		if (widget) return;
 		
 		widget = document.createElement('div');
 		widget.className = 'ai-chat-widget';
-		widget.innerHTML = '<button class="ai-chat-toggle" onclick="toggleAIChat()"><span class="ai-chat-toggle-icon">🤖</span><span class="ai-chat-badge" id="aiChatBadge">AI</span></button><div class="ai-chat-window" id="aiChatWindow"><div class="ai-chat-header"><div class="ai-chat-title"><span>🤖</span><span>AI Помощник</span></div><button class="ai-chat-close" onclick="closeAIChat()">×</button></div><div class="ai-chat-messages" id="aiChatMessages"><div class="ai-message"><div class="ai-avatar">🤖</div><div class="ai-message-content"><div class="ai-message-text">Привет! Я помогу вам с любыми вопросами. Просто напишите что вас интересует.</div><div class="ai-message-time">сейчас</div></div></div></div><div class="ai-quick-buttons"><button class="ai-quick-btn" onclick="sendQuickMessage(\'Как дела?\')">👋 Привет</button><button class="ai-quick-btn" onclick="sendQuickMessage(\'Помоги с кодом\')">💻 Код</button><button class="ai-quick-btn" onclick="sendQuickMessage(\'Объясни концепцию\')">📚 Обучение</button></div><div class="ai-input-row"><input type="text" id="aiChatInput" placeholder="Напишите сообщение..." maxlength="500"><button id="aiSendButton" onclick="sendAIMessage()">➤</button></div></div>';
+		widget.innerHTML = '<button class="ai-chat-toggle" onclick="toggleAIChat()"><span class="ai-chat-toggle-icon">🤖</span><span class="ai-chat-badge" id="aiChatBadge">AI</span></button><div class="ai-chat-window" id="aiChatWindow"><div class="ai-chat-header"><div class="ai-chat-title"><span>🤖</span><span>AI Помощник</span></div><button class="ai-chat-close" onclick="closeAIChat()">×</button></div><div class="ai-chat-messages" id="aiChatMessages"><div class="ai-message"><div class="ai-avatar">🤖</div><div class="ai-message-content"><div class="ai-message-text" id="aiChatWelcome"></div><div class="ai-message-time">сейчас</div></div></div></div><div class="ai-quick-buttons" id="aiQuickButtons"></div><div class="ai-input-row"><input type="text" id="aiChatInput" placeholder="Напишите сообщение..." maxlength="500"><button id="aiSendButton" onclick="sendAIMessage()">➤</button></div></div>';
 		
 		document.body.appendChild(widget);
 		
@@ -79,6 +195,19 @@ func serveEmbeddedChatNew(w http.ResponseWriter, r *http.Request) {
 		input = document.getElementById('aiChatInput');
 		sendBtn = document.getElementById('aiSendButton');
 		badge = document.getElementById('aiChatBadge');
+
+		// Приветствие и быстрые кнопки сайта: текст вставляется без разметки
+		document.getElementById('aiChatWelcome').textContent = site.welcome;
+		var quickButtons = document.getElementById('aiQuickButtons');
+		site.quickButtons.forEach(function(button) {
+			var quickBtn = document.createElement('button');
+			quickBtn.className = 'ai-quick-btn';
+			quickBtn.textContent = button.label;
+			quickBtn.addEventListener('click', function() {
+				sendQuickMessage(button.message);
+			});
+			quickButtons.appendChild(quickBtn);
+		});
 		
 		input.addEventListener('keypress', function(e) {
 			if (e.key === 'Enter' && !e.shiftKey) {
@@ -97,6 +226,7 @@ func serveEmbeddedChatNew(w http.ResponseWriter, r *http.Request) {
 		setupDragHandlers();
 		
 		checkAIStatus();
+		restoreConversation();
 	}
 
 	window.toggleAIChat = function() {
@@ -135,7 +265,7 @@ func serveEmbeddedChatNew(w http.ResponseWriter, r *http.Request) {
 
 	async function checkAIStatus() {
 		try {
-			var response = await fetch('` + baseURL + `/api/status');
+			var response = await fetch(statusUrl);
 			var status = await response.json();
 			
 			if (status.configured && status.available) {
@@ -159,31 +289,159 @@ func serveEmbeddedChatNew(w http.ResponseWriter, r *http.Request) {
 		input.value = '';
 		showTyping();
 
-		history.push({role: 'user', content: message});
-
 		try {
-			var requestBody = {message: message, history: history};
+			var requestBody = {message: message};
+			if (conversationId) {
+				requestBody.conversation_id = conversationId;
+			}
 			if (systemPrompt) {
 				requestBody.systemPrompt = systemPrompt;
 			}
+			if (promptPreset) {
+				requestBody.preset = promptPreset;
+			}
+			// Переменные шаблонов промпта на сервере
+			requestBody.language = navigator.language || '';
+			requestBody.page_url = window.location.href;
+			requestBody.page_title = document.title;
 			
-			var response = await fetch(apiUrl, {
+			var response = await fetch(streamUrl, {
 				method: 'POST',
-				headers: {'Content-Type': 'application/json'},
+				headers: {'Content-Type': 'application/json', 'Accept': 'text/event-stream'},
 				body: JSON.stringify(requestBody)
 			});
 
-			if (!response.ok) throw new Error('HTTP ' + response.status);
+			if (!response.ok) {
+				var failure = {code: ''};
+				try {
+					failure = await response.json();
+				} catch (e) {
+					// Ответ не в формате JSON (прокси перед сервером)
+				}
+				throw chatError(failure);
+			}
 
-			var data = await response.json();
-			
-			hideTyping();
-			addMessage(data.response, 'ai');
-			history.push({role: 'assistant', content: data.response});
+			var reply = '';
+			if (response.body && window.TextDecoder) {
+				reply = await readStream(response);
+			} else {
+				var data = await response.json();
+				hideTyping();
+				addMessage(data.response, 'ai');
+				reply = data.response;
+				saveConversationId(data.conversation_id);
+			}
 
 		} catch (error) {
 			hideTyping();
-			addMessage('Извините, произошла ошибка. Попробуйте еще раз.', 'ai');
+			addMessage(errorMessages[error.code] || 'Извините, произошла ошибка. Попробуйте еще раз.', 'ai');
+		}
+	}
+
+	// Ошибка с кодом из ответа сервера: {code, message, request_id}
+	function chatError(failure) {
+		var error = new Error(failure.message || failure.code);
+		error.code = failure.code;
+		error.requestId = failure.request_id;
+		return error;
+	}
+
+	// Читает ответ сервера в формате Server-Sent Events и выводит текст по мере поступления
+	async function readStream(response) {
+		var reader = response.body.getReader();
+		var decoder = new TextDecoder();
+		var buffer = '';
+		var reply = '';
+		var textEl = null;
+		var finished = false;
+
+		while (true) {
+			var chunk = await reader.read();
+			if (chunk.done) break;
+			buffer += decoder.decode(chunk.value, {stream: true});
+
+			var boundary;
+			while ((boundary = buffer.indexOf('\n\n')) !== -1) {
+				var frame = buffer.slice(0, boundary);
+				buffer = buffer.slice(boundary + 2);
+
+				var event = 'message';
+				var data = '';
+				frame.split('\n').forEach(function(line) {
+					if (line.indexOf('event:') === 0) event = line.slice(6).trim();
+					else if (line.indexOf('data:') === 0) data += line.slice(5).trim();
+				});
+				if (!data) continue;
+				var payload = JSON.parse(data);
+
+				if (event === 'delta') {
+					if (!textEl) {
+						hideTyping();
+						isTyping = true;
+						sendBtn.disabled = true;
+						textEl = addMessage('', 'ai');
+					}
+					reply += payload.content;
+					textEl.innerHTML = formatMessage(reply);
+					scrollToBottom();
+				} else if (event === 'done') {
+					reply = payload.response;
+					saveConversationId(payload.conversation_id);
+					finished = true;
+				} else if (event === 'error') {
+					throw chatError(payload);
+				}
+			}
+		}
+
+		// Поток оборвался без done и error (обрыв сети, перезапуск сервера)
+		if (!finished) {
+			throw chatError({code: 'interrupted'});
+		}
+
+		isTyping = false;
+		sendBtn.disabled = false;
+		if (!textEl) {
+			hideTyping();
+			addMessage(reply, 'ai');
+		}
+		return reply;
+	}
+
+	function saveConversationId(id) {
+		if (!id) return;
+		conversationId = id;
+		try {
+			localStorage.setItem(conversationKey, id);
+		} catch (e) {
+			// localStorage может быть недоступен (приватный режим)
+		}
+	}
+
+	// Восстанавливает разговор после перезагрузки страницы: история хранится на сервере
+	async function restoreConversation() {
+		try {
+			conversationId = localStorage.getItem(conversationKey);
+		} catch (e) {
+			conversationId = null;
+		}
+		if (!conversationId) return;
+
+		try {
+			var response = await fetch(conversationUrl + '&id=' + encodeURIComponent(conversationId));
+			if (response.status === 404) {
+				conversationId = null;
+				localStorage.removeItem(conversationKey);
+				return;
+			}
+			if (!response.ok) return;
+
+			var data = await response.json();
+			(data.messages || []).forEach(function(msg) {
+				addMessage(msg.content, msg.role === 'user' ? 'user' : 'ai');
+			});
+		} catch (e) {
+			// Игнорируем ошибки восстановления: новый ответ начнет разговор заново
 		}
 	}
 
@@ -202,6 +460,7 @@ func serveEmbeddedChatNew(w http.ResponseWriter, r *http.Request) {
 		
 		messages.appendChild(messageDiv);
 		scrollToBottom();
+		return messageDiv.querySelector('.' + sender + '-message-text');
 	}
 
 	function formatMessage(content) {
@@ -2,148 +2,905 @@ package config
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
 // Config структура конфигурации
 type Config struct {
-	Host           string
-	Port           string
-	OpenRouterKey  string
-	OpenRouterModel string
-	OpenAIKey      string
-	OpenAIModel    string
-	MaxTokens      int
-	Temperature    float64
-	Timeout        int
+	Host         string
+	Port         string
+	Providers    []ProviderConfig // Провайдеры AI в порядке fallback
+	MaxTokens    int
+	Temperature  float64
+	Timeout      int
+	SystemPrompt string
+
+	HealthCheckInterval int // Период проверки доступности провайдеров, секунды
+
+	RetryAttempts           int // Повторов временной ошибки у одного провайдера
+	CircuitBreakerThreshold int // Ошибок подряд до временного отключения провайдера, 0 - не отключать
+	CircuitBreakerCooldown  int // На сколько секунд отключается провайдер
+
+	SessionTTL   int    // Время жизни разговора без активности, минуты
+	SessionStore string // Хранилище разговоров: memory или sqlite
+	SQLitePath   string // Путь к базе SQLite для SessionStore=sqlite
+
+	ContextStrategy      string // Сокращение длинной истории: drop-oldest, sliding-window, summarize
+	ContextWindow        int    // Число последних сообщений для sliding-window
+	DefaultContextLength int    // Размер контекста модели, если он неизвестен
+	SummaryThreshold     int    // Число несжатых сообщений, после которого обновляется краткое содержание
+	SummaryKeepRecent    int    // Число последних сообщений, которые не сжимаются
+
+	// Бюджет расхода. Нулевой лимит - без ограничения.
+	UsageFile           string  // Файл со статистикой расхода, переживающей перезапуск
+	BudgetDailyTokens   int     // Лимит токенов в день
+	BudgetDailyCost     float64 // Лимит расходов в день, USD
+	BudgetMonthlyTokens int     // Лимит токенов в месяц
+	BudgetMonthlyCost   float64 // Лимит расходов в месяц, USD
+	BudgetSoftPercent   int     // Процент лимита, после которого используется BudgetFallbackModel
+	BudgetFallbackModel string  // Более дешевая модель основного провайдера для мягкого лимита
+	BudgetMessage       string  // Ответ пользователю, когда лимит исчерпан
+
+	// Ограничение частоты запросов к /api/chat. Ноль - без ограничения.
+	RateLimitIP                int      // Запросов в минуту с одного IP
+	RateLimitIPBurst           int      // Запас запросов с одного IP подряд
+	RateLimitConversation      int      // Запросов в минуту в одном разговоре
+	RateLimitConversationBurst int      // Запас запросов в одном разговоре подряд
+	MaxConcurrent              int      // Одновременных запросов к AI на весь сервер
+	TrustedProxies             []string // Сети прокси, которым доверяется X-Forwarded-For
+
+	AllowedOrigins []string // Сайты, на которых разрешен виджет; пусто - любые
+
+	Sites []SiteConfig // Сайты со своими настройками, выбираются ключом data-site-key
+
+	// Промпты из запроса клиента: deny - игнорируются, presets - только выбор
+	// пресета по id, append - текст клиента добавляется к промпту сервера
+	PromptPolicy  string
+	PromptsFile   string         // YAML файл с пресетами промптов; нет файла - DefaultPromptPresets
+	PromptPreset  string         // Пресет вместо SYSTEM_PROMPT
+	PromptPresets []PromptPreset // Пресеты из файла конфигурации, заменяют PromptsFile
+
+	// Доступ к /admin: пароль (хэш PBKDF2, см. --hash-password) или токен.
+	// Если не задано ни то, ни другое, админка отключена.
+	AdminPasswordHash string
+	AdminToken        string
+
+	// Зашифрованный файл с ключами API и токеном админки. Открывается
+	// паролем из SECRETS_PASSPHRASE (не сохраняется в .env) или файлом ключа.
+	SecretsFile    string
+	SecretsKeyFile string
+
+	problems []string          // Значения, которые не удалось разобрать, см. Validate
+	external map[string]string // Секреты из файлов <ИМЯ>_FILE
+	secrets  map[string]string // Секреты из SecretsFile
+	loaded   map[string]string // Значения EnvValues после Load, см. Save
+}
+
+// SiteConfig настройки сайта, на котором встроен виджет. Переменные
+// окружения сайта строятся из его имени: для "shop" это SITE_SHOP_KEY,
+// SITE_SHOP_SYSTEM_PROMPT и т.д. Пустые значения берутся из общих настроек.
+type SiteConfig struct {
+	Name           string
+	Key            string // Публичный ключ сайта из data-site-key
 	SystemPrompt   string
+	Provider       string // Провайдер, к которому запросы идут в первую очередь
+	Model          string // Модель этого провайдера
+	AllowedOrigins []string
+	PromptPolicy   string // Политика промптов клиента для этого сайта
+	PromptPreset   string // Пресет вместо SystemPrompt
+	Hedge          Hedge  // Второй параллельный запрос, если пресет не задает свой
+
+	RateLimitIP                int
+	RateLimitIPBurst           int
+	RateLimitConversation      int
+	RateLimitConversationBurst int
+
+	PrimaryColor   string
+	SecondaryColor string
+	AccentColor    string
+	Welcome        string        // Приветствие в окне чата
+	QuickButtons   []QuickButton // Кнопки быстрых сообщений
+}
+
+// QuickButton кнопка быстрого сообщения в виджете
+type QuickButton struct {
+	Label   string `json:"label" yaml:"label"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// PromptPreset именованный промпт, который выбирается по id. Template -
+// шаблон text/template с переменными запроса ({{.Date}}, {{.SiteName}} и т.д.)
+type PromptPreset struct {
+	ID          string `yaml:"id"`
+	Description string `yaml:"description"`
+	Template    string `yaml:"template"`
+	Hedge       Hedge  `yaml:"hedge,omitempty"`
+}
+
+// Hedge второй запрос к другому провайдеру или модели для страниц, где
+// важна скорость ответа: используется ответ, пришедший первым
+type Hedge struct {
+	Provider string `yaml:"provider,omitempty"` // Пусто - основной провайдер сайта
+	Model    string `yaml:"model,omitempty"`    // Пусто - модель этого провайдера
+	DelayMs  int    `yaml:"delay_ms,omitempty"` // Пауза перед вторым запросом, 0 - сразу
+}
+
+// Enabled сообщает, задан ли второй запрос
+func (h Hedge) Enabled() bool {
+	return h.Provider != "" || h.Model != ""
+}
+
+// Политики промптов клиента
+const (
+	PromptPolicyDeny    = "deny"
+	PromptPolicyPresets = "presets"
+	PromptPolicyAppend  = "append"
+)
+
+// DefaultPromptPresets промпты, доступные, если PROMPT_PRESETS не задан
+var DefaultPromptPresets = []PromptPreset{
+	{
+		ID:          "friendly",
+		Description: "Дружелюбный помощник",
+		Template:    "Ты дружелюбный AI помощник. Общайся тепло и неформально, используй эмодзи.",
+	},
+	{
+		ID:          "consultant",
+		Description: "Профессиональный консультант",
+		Template:    "Ты профессиональный консультант. Давай четкие, структурированные ответы с примерами.",
+	},
+	{
+		ID:          "mentor",
+		Description: "Программист-наставник",
+		Template:    "Ты опытный программист. Помогай с кодом, объясняй концепции, предлагай лучшие практики.",
+	},
+	{
+		ID:          "creative",
+		Description: "Креативный помощник",
+		Template:    "Ты креативный помощник. Генерируй идеи, помогай с творческими задачами, вдохновляй.",
+	},
+}
+
+// Presets возвращает пресеты промптов из файла конфигурации или из PromptsFile
+func (c *Config) Presets() ([]PromptPreset, error) {
+	if len(c.PromptPresets) > 0 {
+		return c.PromptPresets, nil
+	}
+	return LoadPromptPresets(c.PromptsFile)
+}
+
+// LoadPromptPresets читает пресеты промптов из YAML файла:
+//
+//	presets:
+//	  - id: support
+//	    description: Поддержка
+//	    template: Ты специалист поддержки сайта {{.SiteName}}.
+//
+// Если файла нет, возвращает DefaultPromptPresets.
+func LoadPromptPresets(path string) ([]PromptPreset, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultPromptPresets, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompts file: %w", err)
+	}
+
+	var file struct {
+		Presets []PromptPreset `yaml:"presets"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse prompts file %s: %w", path, err)
+	}
+	return file.Presets, nil
+}
+
+// ProviderConfig конфигурация провайдера AI.
+// Переменные окружения провайдера строятся из его имени:
+// для "openrouter" это OPENROUTER_API_KEY, OPENROUTER_MODEL, OPENROUTER_URL и т.д.
+type ProviderConfig struct {
+	Name     string
+	Type     string
+	URL      string
+	APIKey   string
+	Model    string
+	Headers  map[string]string
+	Priority int
+}
+
+// DefaultProviders список провайдеров, если AI_PROVIDERS не задан
+const DefaultProviders = "openrouter,openai"
+
+// DefaultBudgetMessage ответ пользователю, когда бюджет исчерпан
+const DefaultBudgetMessage = "Ассистент временно недоступен. Пожалуйста, попробуйте позже."
+
+// defaultModels модели по умолчанию для известных провайдеров
+var defaultModels = map[string]string{
+	"openrouter": "anthropic/claude-3.5-sonnet",
+	"openai":     "gpt-4o",
+	"anthropic":  "claude-3-5-sonnet-latest",
+	"ollama":     "llama3.1",
+}
+
+// EnvFile файл с настройками, который читает Load и записывает Save
+const EnvFile = ".env"
+
+// processEnv переменные окружения процесса на момент запуска. Они имеют
+// приоритет над .env и не меняются при перезагрузке конфигурации.
+var processEnv = environ()
+
+// environment значения переменных конфигурации и ошибки их разбора
+type environment struct {
+	values   map[string]string
+	problems []string
+	external map[string]string // Секреты из файлов <ИМЯ>_FILE
+	secrets  map[string]string // Секреты из SECRETS_FILE
+}
+
+func environ() map[string]string {
+	env := make(map[string]string)
+	for _, pair := range os.Environ() {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// readEnv собирает переменные конфигурации по уровням: файл конфигурации,
+// поверх него .env, затем файл секретов, поверх всего - переменные
+// окружения процесса. Секреты можно также передать файлами <ИМЯ>_FILE.
+// Файлы читаются при каждом вызове (godotenv.Load не перезаписывает уже
+// заданные переменные), поэтому повторный Load видит их изменения.
+func readEnv() (*environment, *FileConfig, error) {
+	env := &environment{
+		values:   make(map[string]string),
+		external: make(map[string]string),
+		secrets:  make(map[string]string),
+	}
+	var structured *FileConfig
+	if File != "" {
+		var err error
+		if structured, err = readFile(File); err != nil {
+			return nil, nil, err
+		}
+		env.values = structured.values()
+	}
+
+	file, err := godotenv.Read(EnvFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", EnvFile, err)
+	}
+	for key, value := range file {
+		env.values[key] = value
+	}
+	for key, value := range processEnv {
+		env.values[key] = value
+	}
+
+	readSecretFiles(env)
+	if err := readSecretsFile(env); err != nil {
+		return nil, nil, err
+	}
+	return env, structured, nil
 }
 
-// Load загружает конфигурацию из .env файла и переменных окружения
+// Load загружает конфигурацию из файла конфигурации, .env и переменных
+// окружения. Ошибка возвращается, только если файл не удалось прочитать;
+// неверные значения заменяются значениями по умолчанию и сообщаются Validate.
 func Load() (*Config, error) {
-	// Загружаем .env файл если он существует
-	_ = godotenv.Load()
+	env, file, err := readEnv()
+	if err != nil {
+		return nil, err
+	}
 
 	cfg := &Config{
-		Host:           getEnv("HOST", "0.0.0.0"),
-		Port:           getEnv("PORT", "8080"),
-		OpenRouterKey:  getEnv("OPENROUTER_API_KEY", ""),
-		OpenRouterModel: getEnv("OPENROUTER_MODEL", "anthropic/claude-3.5-sonnet"),
-		OpenAIKey:      getEnv("OPENAI_API_KEY", ""),
-		OpenAIModel:    getEnv("OPENAI_MODEL", "gpt-4o"),
-		MaxTokens:      getEnvInt("MAX_TOKENS", 4000),
-		Temperature:    getEnvFloat("TEMPERATURE", 0.3),
-		Timeout:        getEnvInt("TIMEOUT", 30),
-		SystemPrompt:   getEnv("SYSTEM_PROMPT", "Ты полезный AI ассистент. Отвечай кратко и по делу на русском языке."),
+		Host:         env.get("HOST", "0.0.0.0"),
+		Port:         env.get("PORT", "8080"),
+		MaxTokens:    env.getInt("MAX_TOKENS", 4000),
+		Temperature:  env.getFloat("TEMPERATURE", 0.3),
+		Timeout:      env.getInt("TIMEOUT", 30),
+		SystemPrompt: env.get("SYSTEM_PROMPT", "Ты полезный AI ассистент. Отвечай кратко и по делу на русском языке."),
+
+		HealthCheckInterval: env.getInt("HEALTH_CHECK_INTERVAL", 60),
+
+		RetryAttempts:           env.getInt("RETRY_ATTEMPTS", 2),
+		CircuitBreakerThreshold: env.getInt("CIRCUIT_BREAKER_THRESHOLD", 5),
+		CircuitBreakerCooldown:  env.getInt("CIRCUIT_BREAKER_COOLDOWN", 30),
+
+		SessionTTL:   env.getInt("SESSION_TTL", 60),
+		SessionStore: env.get("SESSION_STORE", "memory"),
+		SQLitePath:   env.get("SQLITE_PATH", "ai-bot.db"),
+
+		ContextStrategy:      env.get("CONTEXT_STRATEGY", "drop-oldest"),
+		ContextWindow:        env.getInt("CONTEXT_WINDOW", 20),
+		DefaultContextLength: env.getInt("DEFAULT_CONTEXT_LENGTH", 8192),
+		SummaryThreshold:     env.getInt("SUMMARY_THRESHOLD", 30),
+		SummaryKeepRecent:    env.getInt("SUMMARY_KEEP_RECENT", 10),
+
+		UsageFile:           env.get("USAGE_FILE", "usage.json"),
+		BudgetDailyTokens:   env.getInt("BUDGET_DAILY_TOKENS", 0),
+		BudgetDailyCost:     env.getFloat("BUDGET_DAILY_COST", 0),
+		BudgetMonthlyTokens: env.getInt("BUDGET_MONTHLY_TOKENS", 0),
+		BudgetMonthlyCost:   env.getFloat("BUDGET_MONTHLY_COST", 0),
+		BudgetSoftPercent:   env.getInt("BUDGET_SOFT_PERCENT", 80),
+		BudgetFallbackModel: env.get("BUDGET_FALLBACK_MODEL", ""),
+		BudgetMessage:       env.get("BUDGET_MESSAGE", DefaultBudgetMessage),
+
+		RateLimitIP:                env.getInt("RATE_LIMIT_IP", 20),
+		RateLimitIPBurst:           env.getInt("RATE_LIMIT_IP_BURST", 5),
+		RateLimitConversation:      env.getInt("RATE_LIMIT_CONVERSATION", 10),
+		RateLimitConversationBurst: env.getInt("RATE_LIMIT_CONVERSATION_BURST", 3),
+		MaxConcurrent:              env.getInt("MAX_CONCURRENT", 10),
+		TrustedProxies:             splitList(env.get("TRUSTED_PROXIES", "")),
+
+		AllowedOrigins: splitList(env.get("ALLOWED_ORIGINS", "")),
+
+		PromptPolicy: env.get("PROMPT_POLICY", PromptPolicyPresets),
+		PromptsFile:  env.get("PROMPTS_FILE", "prompts.yaml"),
+		PromptPreset: env.get("PROMPT_PRESET", ""),
+
+		AdminPasswordHash: env.get("ADMIN_PASSWORD_HASH", ""),
+		AdminToken:        env.get("ADMIN_TOKEN", ""),
+
+		SecretsFile:    env.get("SECRETS_FILE", ""),
+		SecretsKeyFile: env.get("SECRETS_KEY_FILE", ""),
+	}
+
+	for _, name := range strings.Split(env.get("AI_PROVIDERS", DefaultProviders), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		cfg.Providers = append(cfg.Providers, loadProvider(env, name))
+	}
+
+	for _, name := range splitList(env.get("SITES", "")) {
+		cfg.Sites = append(cfg.Sites, loadSite(env, name, cfg))
 	}
 
+	if file != nil {
+		cfg.PromptPresets = file.Prompts.Presets
+	}
+	cfg.problems = env.problems
+	cfg.external = env.external
+	cfg.secrets = env.secrets
+	_, cfg.loaded = cfg.EnvValues()
+
 	return cfg, nil
 }
 
-// Save сохраняет конфигурацию в .env файл
+// SiteEnvPrefix возвращает префикс переменных окружения сайта
+func SiteEnvPrefix(name string) string {
+	return "SITE_" + EnvPrefix(name)
+}
+
+// loadSite читает настройки сайта из переменных окружения. Лимиты запросов
+// по умолчанию совпадают с общими.
+func loadSite(env *environment, name string, cfg *Config) SiteConfig {
+	prefix := SiteEnvPrefix(name)
+	return SiteConfig{
+		Name:           name,
+		Key:            env.get(prefix+"_KEY", name),
+		SystemPrompt:   env.get(prefix+"_SYSTEM_PROMPT", ""),
+		Provider:       env.get(prefix+"_PROVIDER", ""),
+		Model:          env.get(prefix+"_MODEL", ""),
+		AllowedOrigins: splitList(env.get(prefix+"_ALLOWED_ORIGINS", "")),
+		PromptPolicy:   env.get(prefix+"_PROMPT_POLICY", cfg.PromptPolicy),
+		PromptPreset:   env.get(prefix+"_PROMPT_PRESET", cfg.PromptPreset),
+		Hedge: Hedge{
+			Provider: env.get(prefix+"_HEDGE_PROVIDER", ""),
+			Model:    env.get(prefix+"_HEDGE_MODEL", ""),
+			DelayMs:  env.getInt(prefix+"_HEDGE_DELAY_MS", 0),
+		},
+
+		RateLimitIP:                env.getInt(prefix+"_RATE_LIMIT_IP", cfg.RateLimitIP),
+		RateLimitIPBurst:           env.getInt(prefix+"_RATE_LIMIT_IP_BURST", cfg.RateLimitIPBurst),
+		RateLimitConversation:      env.getInt(prefix+"_RATE_LIMIT_CONVERSATION", cfg.RateLimitConversation),
+		RateLimitConversationBurst: env.getInt(prefix+"_RATE_LIMIT_CONVERSATION_BURST", cfg.RateLimitConversationBurst),
+
+		PrimaryColor:   env.get(prefix+"_PRIMARY_COLOR", ""),
+		SecondaryColor: env.get(prefix+"_SECONDARY_COLOR", ""),
+		AccentColor:    env.get(prefix+"_ACCENT_COLOR", ""),
+		Welcome:        env.get(prefix+"_WELCOME", ""),
+		QuickButtons:   parseQuickButtons(env.get(prefix+"_QUICK_BUTTONS", "")),
+	}
+}
+
+// loadProvider читает настройки провайдера из переменных окружения
+func loadProvider(env *environment, name string) ProviderConfig {
+	prefix := EnvPrefix(name)
+	return ProviderConfig{
+		Name:     name,
+		Type:     env.get(prefix+"_TYPE", DefaultType(name)),
+		URL:      env.get(prefix+"_URL", ""),
+		APIKey:   env.get(prefix+"_API_KEY", ""),
+		Model:    env.get(prefix+"_MODEL", defaultModels[name]),
+		Headers:  parseHeaders(env.get(prefix+"_HEADERS", "")),
+		Priority: env.getInt(prefix+"_PRIORITY", 0),
+	}
+}
+
+// EnvPrefix возвращает префикс переменных окружения для провайдера
+func EnvPrefix(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// DefaultType возвращает тип провайдера по его имени: известные имена
+// совпадают с типом, остальные считаются OpenAI-совместимыми
+func DefaultType(name string) string {
+	switch name {
+	case "openrouter", "openai", "anthropic", "ollama":
+		return name
+	}
+	return "openai-compatible"
+}
+
+// Provider возвращает провайдера по имени или nil
+func (c *Config) Provider(name string) *ProviderConfig {
+	for i := range c.Providers {
+		if c.Providers[i].Name == name {
+			return &c.Providers[i]
+		}
+	}
+	return nil
+}
+
+// EnsureProvider возвращает провайдера по имени, добавляя его в конец списка при отсутствии
+func (c *Config) EnsureProvider(name string) *ProviderConfig {
+	if provider := c.Provider(name); provider != nil {
+		return provider
+	}
+	c.Providers = append(c.Providers, ProviderConfig{
+		Name:  name,
+		Type:  DefaultType(name),
+		Model: defaultModels[name],
+	})
+	return &c.Providers[len(c.Providers)-1]
+}
+
+// Save записывает в .env значения, которые изменились после Load. Остальные
+// строки .env, включая комментарии, остаются как были, а значения из файла
+// конфигурации и значения по умолчанию не копируются в .env. Переменная с
+// пустым значением удаляется из файла.
 func Save(cfg *Config) error {
-	envFile := ".env"
-	
-	// Читаем существующий файл если он есть
-	existing := make(map[string]string)
-	if file, err := os.Open(envFile); err == nil {
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line == "" || strings.HasPrefix(line, "#") {
-				continue
-			}
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				existing[parts[0]] = parts[1]
-			}
+	lines, err := readEnvLines(EnvFile)
+	if err != nil {
+		return err
+	}
+
+	keys, values := cfg.EnvValues()
+	changed := make(map[string]bool)
+	for key, value := range values {
+		if loaded, ok := cfg.loaded[key]; !ok || loaded != value {
+			changed[key] = true
+		}
+	}
+
+	// Секреты из файлов <ИМЯ>_FILE и из файла секретов (см. SaveSecrets)
+	// не записываются в .env открытым текстом, а прежнее значение в .env
+	// удаляется. Если от файла секретов отказались, его ключи переносятся
+	// в .env. Секрет, заданный не в .env (переменной окружения, файлом
+	// конфигурации), в .env не попадает совсем: новый ключ записывается,
+	// только если его не было ни в одном источнике.
+	inEnvFile := make(map[string]bool)
+	for _, line := range lines {
+		inEnvFile[envLineKey(line)] = true
+	}
+	for _, key := range cfg.secretKeys() {
+		external, fromFile := cfg.external[key]
+		secret, fromSecrets := cfg.secrets[key]
+		switch {
+		case (fromFile && external == values[key]) || (cfg.SecretsFile != "" && fromSecrets && secret == values[key]):
+			values[key] = ""
+			changed[key] = true
+		case cfg.SecretsFile == "" && fromSecrets && values[key] != "" && processEnv[key] == "":
+			changed[key] = true
+		case !inEnvFile[key] && (cfg.loaded[key] != "" || processEnv[key] != ""):
+			delete(changed, key)
 		}
-		file.Close()
 	}
 
-	// Обновляем значения
-	existing["HOST"] = cfg.Host
-	existing["PORT"] = cfg.Port
-	existing["OPENROUTER_API_KEY"] = cfg.OpenRouterKey
-	existing["OPENROUTER_MODEL"] = cfg.OpenRouterModel
-	existing["OPENAI_API_KEY"] = cfg.OpenAIKey
-	existing["OPENAI_MODEL"] = cfg.OpenAIModel
-	existing["MAX_TOKENS"] = strconv.Itoa(cfg.MaxTokens)
-	existing["TEMPERATURE"] = fmt.Sprintf("%.2f", cfg.Temperature)
-	existing["TIMEOUT"] = strconv.Itoa(cfg.Timeout)
-	existing["SYSTEM_PROMPT"] = cfg.SystemPrompt
+	// Изменившиеся переменные заменяются на месте, новые дописываются в
+	// конец в порядке keys
+	var out []string
+	written := make(map[string]bool)
+	for _, line := range lines {
+		key := envLineKey(line)
+		if !changed[key] {
+			out = append(out, line)
+			continue
+		}
+		if !written[key] && values[key] != "" {
+			out = append(out, key+"="+formatEnvValue(values[key]))
+		}
+		written[key] = true
+	}
+	for _, key := range keys {
+		if changed[key] && !written[key] && values[key] != "" {
+			out = append(out, key+"="+formatEnvValue(values[key]))
+		}
+	}
 
-	// Записываем обратно
-	file, err := os.Create(envFile)
+	// Записываем во временный файл и заменяем .env целиком, чтобы
+	// перезагрузка конфигурации не прочитала файл наполовину записанным
+	tmpFile := EnvFile + ".tmp"
+	file, err := os.Create(tmpFile)
 	if err != nil {
 		return fmt.Errorf("failed to create .env file: %w", err)
 	}
+	defer os.Remove(tmpFile)
 	defer file.Close()
 
 	writer := bufio.NewWriter(file)
-	
-	// Записываем в определенном порядке
-	keys := []string{
-		"HOST", "PORT",
-		"OPENROUTER_API_KEY", "OPENROUTER_MODEL",
-		"OPENAI_API_KEY", "OPENAI_MODEL",
-		"MAX_TOKENS", "TEMPERATURE", "TIMEOUT",
-		"SYSTEM_PROMPT",
+	for _, line := range out {
+		fmt.Fprintln(writer, line)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, EnvFile); err != nil {
+		return err
 	}
 
-	for _, key := range keys {
-		if value, ok := existing[key]; ok && value != "" {
-			fmt.Fprintf(writer, "%s=%s\n", key, value)
+	// Следующий Save сравнивает с только что записанными значениями
+	if cfg.loaded == nil {
+		cfg.loaded = make(map[string]string)
+	}
+	for key := range changed {
+		cfg.loaded[key] = values[key]
+	}
+	return nil
+}
+
+// readEnvLines читает .env по записям: многострочное значение в кавычках
+// остается одной записью. Отсутствующий файл считается пустым.
+func readEnvLines(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var lines []string
+	var entry []string
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		entry = append(entry, line)
+		if !openQuote(strings.Join(entry, "\n")) {
+			lines = append(lines, strings.Join(entry, "\n"))
+			entry = nil
 		}
 	}
+	if entry != nil {
+		lines = append(lines, strings.Join(entry, "\n"))
+	}
+	return lines, nil
+}
+
+// envLineKey возвращает имя переменной записи .env или пустую строку для
+// комментария и пустой строки
+func envLineKey(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+	line = strings.TrimPrefix(line, "export ")
+	key, _, ok := strings.Cut(line, "=")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(key)
+}
+
+// openQuote проверяет, что значение записи начинается с кавычки и еще не
+// закрыто, то есть продолжается на следующей строке
+func openQuote(entry string) bool {
+	if envLineKey(entry) == "" {
+		return false
+	}
+	_, value, _ := strings.Cut(entry, "=")
+	value = strings.TrimSpace(value)
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		return false
+	}
+	quote := value[0]
+	for i := 1; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && quote == '"':
+			i++
+		case value[i] == quote:
+			return false
+		}
+	}
+	return true
+}
 
-	// Записываем остальные ключи
-	for key, value := range existing {
-		found := false
-		for _, k := range keys {
-			if k == key {
-				found = true
-				break
+// EnvValues возвращает значения переменных .env для конфигурации и их
+// порядок в файле. Пустое значение означает, что переменная не задана.
+func (cfg *Config) EnvValues() ([]string, map[string]string) {
+	values := make(map[string]string)
+	values["HOST"] = cfg.Host
+	values["PORT"] = cfg.Port
+	values["MAX_TOKENS"] = strconv.Itoa(cfg.MaxTokens)
+	values["TEMPERATURE"] = fmt.Sprintf("%.2f", cfg.Temperature)
+	values["TIMEOUT"] = strconv.Itoa(cfg.Timeout)
+	values["HEALTH_CHECK_INTERVAL"] = strconv.Itoa(cfg.HealthCheckInterval)
+	values["RETRY_ATTEMPTS"] = strconv.Itoa(cfg.RetryAttempts)
+	values["CIRCUIT_BREAKER_THRESHOLD"] = strconv.Itoa(cfg.CircuitBreakerThreshold)
+	values["CIRCUIT_BREAKER_COOLDOWN"] = strconv.Itoa(cfg.CircuitBreakerCooldown)
+	values["SYSTEM_PROMPT"] = cfg.SystemPrompt
+	values["SESSION_TTL"] = strconv.Itoa(cfg.SessionTTL)
+	values["SESSION_STORE"] = cfg.SessionStore
+	values["SQLITE_PATH"] = cfg.SQLitePath
+	values["CONTEXT_STRATEGY"] = cfg.ContextStrategy
+	values["CONTEXT_WINDOW"] = strconv.Itoa(cfg.ContextWindow)
+	values["DEFAULT_CONTEXT_LENGTH"] = strconv.Itoa(cfg.DefaultContextLength)
+	values["SUMMARY_THRESHOLD"] = strconv.Itoa(cfg.SummaryThreshold)
+	values["SUMMARY_KEEP_RECENT"] = strconv.Itoa(cfg.SummaryKeepRecent)
+	values["USAGE_FILE"] = cfg.UsageFile
+	values["BUDGET_DAILY_TOKENS"] = formatLimit(float64(cfg.BudgetDailyTokens))
+	values["BUDGET_DAILY_COST"] = formatLimit(cfg.BudgetDailyCost)
+	values["BUDGET_MONTHLY_TOKENS"] = formatLimit(float64(cfg.BudgetMonthlyTokens))
+	values["BUDGET_MONTHLY_COST"] = formatLimit(cfg.BudgetMonthlyCost)
+	values["BUDGET_SOFT_PERCENT"] = strconv.Itoa(cfg.BudgetSoftPercent)
+	values["BUDGET_FALLBACK_MODEL"] = cfg.BudgetFallbackModel
+	values["RATE_LIMIT_IP"] = strconv.Itoa(cfg.RateLimitIP)
+	values["RATE_LIMIT_IP_BURST"] = strconv.Itoa(cfg.RateLimitIPBurst)
+	values["RATE_LIMIT_CONVERSATION"] = strconv.Itoa(cfg.RateLimitConversation)
+	values["RATE_LIMIT_CONVERSATION_BURST"] = strconv.Itoa(cfg.RateLimitConversationBurst)
+	values["MAX_CONCURRENT"] = strconv.Itoa(cfg.MaxConcurrent)
+	values["TRUSTED_PROXIES"] = strings.Join(cfg.TrustedProxies, ",")
+	values["ALLOWED_ORIGINS"] = strings.Join(cfg.AllowedOrigins, ",")
+	values["PROMPT_POLICY"] = cfg.PromptPolicy
+	values["PROMPTS_FILE"] = cfg.PromptsFile
+	values["PROMPT_PRESET"] = cfg.PromptPreset
+	values["ADMIN_PASSWORD_HASH"] = cfg.AdminPasswordHash
+	values["ADMIN_TOKEN"] = cfg.AdminToken
+	values["SECRETS_FILE"] = cfg.SecretsFile
+	values["SECRETS_KEY_FILE"] = cfg.SecretsKeyFile
+	values["BUDGET_MESSAGE"] = ""
+	if cfg.BudgetMessage != DefaultBudgetMessage {
+		values["BUDGET_MESSAGE"] = cfg.BudgetMessage
+	}
+
+	// Ключи в порядке записи в файл
+	keys := []string{"HOST", "PORT", "AI_PROVIDERS"}
+
+	names := make([]string, len(cfg.Providers))
+	for i, provider := range cfg.Providers {
+		names[i] = provider.Name
+		prefix := EnvPrefix(provider.Name)
+
+		values[prefix+"_API_KEY"] = provider.APIKey
+		values[prefix+"_MODEL"] = provider.Model
+		values[prefix+"_URL"] = provider.URL
+		values[prefix+"_HEADERS"] = formatHeaders(provider.Headers)
+		values[prefix+"_TYPE"] = ""
+		if provider.Type != DefaultType(provider.Name) {
+			values[prefix+"_TYPE"] = provider.Type
+		}
+		values[prefix+"_PRIORITY"] = ""
+		if provider.Priority != 0 {
+			values[prefix+"_PRIORITY"] = strconv.Itoa(provider.Priority)
+		}
+
+		keys = append(keys,
+			prefix+"_TYPE", prefix+"_URL", prefix+"_API_KEY", prefix+"_MODEL",
+			prefix+"_HEADERS", prefix+"_PRIORITY",
+		)
+	}
+	values["AI_PROVIDERS"] = strings.Join(names, ",")
+
+	siteNames := make([]string, len(cfg.Sites))
+	var siteKeys []string
+	for i, site := range cfg.Sites {
+		siteNames[i] = site.Name
+		prefix := SiteEnvPrefix(site.Name)
+
+		values[prefix+"_KEY"] = site.Key
+		values[prefix+"_SYSTEM_PROMPT"] = site.SystemPrompt
+		values[prefix+"_PROVIDER"] = site.Provider
+		values[prefix+"_MODEL"] = site.Model
+		values[prefix+"_ALLOWED_ORIGINS"] = strings.Join(site.AllowedOrigins, ",")
+		values[prefix+"_PROMPT_POLICY"] = ""
+		if site.PromptPolicy != cfg.PromptPolicy {
+			values[prefix+"_PROMPT_POLICY"] = site.PromptPolicy
+		}
+		values[prefix+"_PROMPT_PRESET"] = ""
+		if site.PromptPreset != cfg.PromptPreset {
+			values[prefix+"_PROMPT_PRESET"] = site.PromptPreset
+		}
+		values[prefix+"_HEDGE_PROVIDER"] = site.Hedge.Provider
+		values[prefix+"_HEDGE_MODEL"] = site.Hedge.Model
+		values[prefix+"_HEDGE_DELAY_MS"] = ""
+		if site.Hedge.DelayMs != 0 {
+			values[prefix+"_HEDGE_DELAY_MS"] = strconv.Itoa(site.Hedge.DelayMs)
+		}
+		values[prefix+"_PRIMARY_COLOR"] = site.PrimaryColor
+		values[prefix+"_SECONDARY_COLOR"] = site.SecondaryColor
+		values[prefix+"_ACCENT_COLOR"] = site.AccentColor
+		values[prefix+"_WELCOME"] = site.Welcome
+		values[prefix+"_QUICK_BUTTONS"] = formatQuickButtons(site.QuickButtons)
+
+		// Лимиты записываются, только если отличаются от общих
+		limits := []struct {
+			key          string
+			value, total int
+		}{
+			{"_RATE_LIMIT_IP", site.RateLimitIP, cfg.RateLimitIP},
+			{"_RATE_LIMIT_IP_BURST", site.RateLimitIPBurst, cfg.RateLimitIPBurst},
+			{"_RATE_LIMIT_CONVERSATION", site.RateLimitConversation, cfg.RateLimitConversation},
+			{"_RATE_LIMIT_CONVERSATION_BURST", site.RateLimitConversationBurst, cfg.RateLimitConversationBurst},
+		}
+		for _, limit := range limits {
+			values[prefix+limit.key] = ""
+			if limit.value != limit.total {
+				values[prefix+limit.key] = strconv.Itoa(limit.value)
 			}
 		}
-		if !found && value != "" {
-			fmt.Fprintf(writer, "%s=%s\n", key, value)
+
+		siteKeys = append(siteKeys,
+			prefix+"_KEY", prefix+"_SYSTEM_PROMPT", prefix+"_PROVIDER", prefix+"_MODEL",
+			prefix+"_ALLOWED_ORIGINS", prefix+"_PROMPT_POLICY", prefix+"_PROMPT_PRESET",
+			prefix+"_HEDGE_PROVIDER", prefix+"_HEDGE_MODEL", prefix+"_HEDGE_DELAY_MS",
+			prefix+"_RATE_LIMIT_IP", prefix+"_RATE_LIMIT_IP_BURST",
+			prefix+"_RATE_LIMIT_CONVERSATION", prefix+"_RATE_LIMIT_CONVERSATION_BURST",
+			prefix+"_PRIMARY_COLOR", prefix+"_SECONDARY_COLOR", prefix+"_ACCENT_COLOR",
+			prefix+"_WELCOME", prefix+"_QUICK_BUTTONS",
+		)
+	}
+	values["SITES"] = strings.Join(siteNames, ",")
+
+	keys = append(keys, "MAX_TOKENS", "TEMPERATURE", "TIMEOUT", "HEALTH_CHECK_INTERVAL",
+		"RETRY_ATTEMPTS", "CIRCUIT_BREAKER_THRESHOLD", "CIRCUIT_BREAKER_COOLDOWN", "SYSTEM_PROMPT",
+		"SESSION_TTL", "SESSION_STORE", "SQLITE_PATH",
+		"CONTEXT_STRATEGY", "CONTEXT_WINDOW", "DEFAULT_CONTEXT_LENGTH",
+		"SUMMARY_THRESHOLD", "SUMMARY_KEEP_RECENT",
+		"USAGE_FILE", "BUDGET_DAILY_TOKENS", "BUDGET_DAILY_COST",
+		"BUDGET_MONTHLY_TOKENS", "BUDGET_MONTHLY_COST",
+		"BUDGET_SOFT_PERCENT", "BUDGET_FALLBACK_MODEL", "BUDGET_MESSAGE",
+		"RATE_LIMIT_IP", "RATE_LIMIT_IP_BURST", "RATE_LIMIT_CONVERSATION",
+		"RATE_LIMIT_CONVERSATION_BURST", "MAX_CONCURRENT", "TRUSTED_PROXIES",
+		"ALLOWED_ORIGINS", "PROMPT_POLICY", "PROMPTS_FILE", "PROMPT_PRESET",
+		"ADMIN_PASSWORD_HASH", "ADMIN_TOKEN", "SECRETS_FILE", "SECRETS_KEY_FILE", "SITES")
+	keys = append(keys, siteKeys...)
+
+	return keys, values
+}
+
+// formatEnvValue записывает многострочные значения в двойных кавычках,
+// как их читает godotenv
+func formatEnvValue(value string) string {
+	if !strings.ContainsAny(value, "\r\n") {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\r", "", "\n", `\n`).Replace(value) + `"`
+}
+
+// formatLimit форматирует лимит для .env; нулевой лимит не записывается
+func formatLimit(value float64) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// splitList разбирает список через запятую, пропуская пустые элементы
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseQuickButtons разбирает кнопки вида "Текст: сообщение; Другой: сообщение".
+// Если сообщение не указано, отправляется текст кнопки.
+func parseQuickButtons(value string) []QuickButton {
+	var buttons []QuickButton
+	for _, item := range strings.Split(value, ";") {
+		label, message, _ := strings.Cut(item, ":")
+		label = strings.TrimSpace(label)
+		message = strings.TrimSpace(message)
+		if label == "" {
+			continue
+		}
+		if message == "" {
+			message = label
 		}
+		buttons = append(buttons, QuickButton{Label: label, Message: message})
 	}
+	return buttons
+}
 
-	return writer.Flush()
+// formatQuickButtons форматирует кнопки в строку для .env
+func formatQuickButtons(buttons []QuickButton) string {
+	items := make([]string, len(buttons))
+	for i, button := range buttons {
+		items[i] = button.Label + ": " + button.Message
+	}
+	return strings.Join(items, "; ")
 }
 
-// getEnv получает значение переменной окружения или возвращает значение по умолчанию
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+// parseHeaders разбирает заголовки вида "Name: value; Other: value"
+func parseHeaders(value string) map[string]string {
+	if value == "" {
+		return nil
+	}
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ";") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		if key != "" {
+			headers[key] = strings.TrimSpace(parts[1])
+		}
+	}
+	return headers
+}
+
+// formatHeaders форматирует заголовки в строку для .env
+func formatHeaders(headers map[string]string) string {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + ": " + headers[key]
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// get получает значение переменной или возвращает значение по умолчанию
+func (env *environment) get(key, defaultValue string) string {
+	if value := env.values[key]; value != "" {
 		return value
 	}
 	return defaultValue
 }
 
-// getEnvInt получает int значение переменной окружения
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
+// getInt получает int значение переменной. Нечисловое значение
+// запоминается как ошибка конфигурации.
+func (env *environment) getInt(key string, defaultValue int) int {
+	if value := env.values[key]; value != "" {
+		intValue, err := strconv.Atoi(strings.TrimSpace(value))
+		if err == nil {
 			return intValue
 		}
+		env.problems = append(env.problems, fmt.Sprintf("%s=%q: expected an integer", key, value))
 	}
 	return defaultValue
 }
 
-// getEnvFloat получает float64 значение переменной окружения
-func getEnvFloat(key string, defaultValue float64) float64 {
-	if value := os.Getenv(key); value != "" {
-		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+// getFloat получает float64 значение переменной. Нечисловое значение
+// запоминается как ошибка конфигурации.
+func (env *environment) getFloat(key string, defaultValue float64) float64 {
+	if value := env.values[key]; value != "" {
+		floatValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err == nil {
 			return floatValue
 		}
+		env.problems = append(env.problems, fmt.Sprintf("%s=%q: expected a number", key, value))
 	}
 	return defaultValue
 }
-
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSaveKeepsUnchangedLines(t *testing.T) {
+	t.Chdir(t.TempDir())
+	env := "# Настройки сервера\nHOST=127.0.0.1\nCUSTOM_VALUE=x\nSYSTEM_PROMPT=\"Первая строка\nвторая строка\"\n"
+	if err := os.WriteFile(EnvFile, []byte(env), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Port = "9090"
+	cfg.Host = ""
+	if err := Save(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(EnvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Настройки сервера\nCUSTOM_VALUE=x\nSYSTEM_PROMPT=\"Первая строка\nвторая строка\"\nPORT=9090\n"
+	if string(data) != want {
+		t.Errorf(".env = %q, want %q", data, want)
+	}
+}
+
+func TestSaveSkipsDefaults(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.MaxTokens = 1000
+	if err := Save(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(EnvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "MAX_TOKENS=1000" {
+		t.Errorf(".env = %q, want only MAX_TOKENS", got)
+	}
+}
+
+func TestSaveSkipsSecretsFromEnvironment(t *testing.T) {
+	cfg := loadEnv(t, map[string]string{"AI_PROVIDERS": "openai", "OPENAI_API_KEY": "sk-from-env"})
+	cfg.Provider("openai").APIKey = "sk-changed"
+	cfg.MaxTokens = 1000
+	if err := Save(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(EnvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "sk-") {
+		t.Errorf(".env contains a key from the environment: %q", data)
+	}
+}
+
+// loadEnv загружает конфигурацию в пустом рабочем каталоге с переменными
+// окружения env вместо окружения процесса
+func loadEnv(t *testing.T, env map[string]string) *Config {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	processEnv = env
+	t.Cleanup(func() { processEnv = environ() })
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
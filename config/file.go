@@ -0,0 +1,384 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File путь к YAML файлу конфигурации (--config-file); пусто - без файла.
+// Значения файла - нижний уровень: их переопределяют .env, переменные
+// окружения и аргументы командной строки.
+var File string
+
+// FileConfig схема файла конфигурации. Пустые строки и отсутствующие
+// числа не задают значение, как и пустые переменные окружения.
+type FileConfig struct {
+	Server         FileServer     `yaml:"server,omitempty"`
+	AI             FileAI         `yaml:"ai,omitempty"`
+	Providers      []FileProvider `yaml:"providers,omitempty"`
+	Sessions       FileSessions   `yaml:"sessions,omitempty"`
+	Context        FileContext    `yaml:"context,omitempty"`
+	Budget         FileBudget     `yaml:"budget,omitempty"`
+	RateLimit      FileRateLimit  `yaml:"rate_limit,omitempty"`
+	AllowedOrigins []string       `yaml:"allowed_origins,omitempty"`
+	Prompts        FilePrompts    `yaml:"prompts,omitempty"`
+	Admin          FileAdmin      `yaml:"admin,omitempty"`
+	Secrets        FileSecrets    `yaml:"secrets,omitempty"`
+	Sites          []FileSite     `yaml:"sites,omitempty"`
+}
+
+type FileServer struct {
+	Host    string `yaml:"host,omitempty"`
+	Port    string `yaml:"port,omitempty"`
+	Timeout *int   `yaml:"timeout,omitempty"` // Таймаут запроса к AI, секунды
+
+	HealthCheckInterval *int `yaml:"health_check_interval,omitempty"` // Период проверки провайдеров, секунды
+}
+
+type FileAI struct {
+	MaxTokens    *int     `yaml:"max_tokens,omitempty"`
+	Temperature  *float64 `yaml:"temperature,omitempty"`
+	SystemPrompt string   `yaml:"system_prompt,omitempty"`
+
+	RetryAttempts           *int `yaml:"retry_attempts,omitempty"`
+	CircuitBreakerThreshold *int `yaml:"circuit_breaker_threshold,omitempty"`
+	CircuitBreakerCooldown  *int `yaml:"circuit_breaker_cooldown,omitempty"` // Секунды
+}
+
+// FileProvider провайдер AI; порядок в списке - порядок fallback
+type FileProvider struct {
+	Name     string            `yaml:"name"`
+	Type     string            `yaml:"type,omitempty"`
+	URL      string            `yaml:"url,omitempty"`
+	APIKey   string            `yaml:"api_key,omitempty"`
+	Model    string            `yaml:"model,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	Priority *int              `yaml:"priority,omitempty"`
+}
+
+type FileSessions struct {
+	Store      string `yaml:"store,omitempty"`
+	TTL        *int   `yaml:"ttl,omitempty"` // Минуты
+	SQLitePath string `yaml:"sqlite_path,omitempty"`
+}
+
+type FileContext struct {
+	Strategy          string `yaml:"strategy,omitempty"`
+	Window            *int   `yaml:"window,omitempty"`
+	DefaultLength     *int   `yaml:"default_length,omitempty"`
+	SummaryThreshold  *int   `yaml:"summary_threshold,omitempty"`
+	SummaryKeepRecent *int   `yaml:"summary_keep_recent,omitempty"`
+}
+
+type FileBudget struct {
+	UsageFile     string   `yaml:"usage_file,omitempty"`
+	DailyTokens   *int     `yaml:"daily_tokens,omitempty"`
+	DailyCost     *float64 `yaml:"daily_cost,omitempty"`
+	MonthlyTokens *int     `yaml:"monthly_tokens,omitempty"`
+	MonthlyCost   *float64 `yaml:"monthly_cost,omitempty"`
+	SoftPercent   *int     `yaml:"soft_percent,omitempty"`
+	FallbackModel string   `yaml:"fallback_model,omitempty"`
+	Message       string   `yaml:"message,omitempty"`
+}
+
+type FileRateLimit struct {
+	IP                *int     `yaml:"ip,omitempty"`
+	IPBurst           *int     `yaml:"ip_burst,omitempty"`
+	Conversation      *int     `yaml:"conversation,omitempty"`
+	ConversationBurst *int     `yaml:"conversation_burst,omitempty"`
+	MaxConcurrent     *int     `yaml:"max_concurrent,omitempty"`
+	TrustedProxies    []string `yaml:"trusted_proxies,omitempty"`
+}
+
+// FilePrompts политика промптов. Пресеты можно задать прямо в файле
+// конфигурации, тогда PROMPTS_FILE не читается.
+type FilePrompts struct {
+	Policy  string         `yaml:"policy,omitempty"`
+	File    string         `yaml:"file,omitempty"`
+	Preset  string         `yaml:"preset,omitempty"`
+	Presets []PromptPreset `yaml:"presets,omitempty"`
+}
+
+type FileAdmin struct {
+	PasswordHash string `yaml:"password_hash,omitempty"`
+	Token        string `yaml:"token,omitempty"`
+}
+
+// FileSecrets зашифрованный файл секретов; пароль в файл конфигурации
+// не записывается, он передается через SECRETS_PASSPHRASE
+type FileSecrets struct {
+	File    string `yaml:"file,omitempty"`
+	KeyFile string `yaml:"key_file,omitempty"`
+}
+
+type FileSite struct {
+	Name           string        `yaml:"name"`
+	Key            string        `yaml:"key,omitempty"`
+	SystemPrompt   string        `yaml:"system_prompt,omitempty"`
+	Provider       string        `yaml:"provider,omitempty"`
+	Model          string        `yaml:"model,omitempty"`
+	AllowedOrigins []string      `yaml:"allowed_origins,omitempty"`
+	PromptPolicy   string        `yaml:"prompt_policy,omitempty"`
+	PromptPreset   string        `yaml:"prompt_preset,omitempty"`
+	Hedge          Hedge         `yaml:"hedge,omitempty"`
+	RateLimit      FileSiteLimit `yaml:"rate_limit,omitempty"`
+	Colors         FileColors    `yaml:"colors,omitempty"`
+	Welcome        string        `yaml:"welcome,omitempty"`
+	QuickButtons   []QuickButton `yaml:"quick_buttons,omitempty"`
+}
+
+type FileSiteLimit struct {
+	IP                *int `yaml:"ip,omitempty"`
+	IPBurst           *int `yaml:"ip_burst,omitempty"`
+	Conversation      *int `yaml:"conversation,omitempty"`
+	ConversationBurst *int `yaml:"conversation_burst,omitempty"`
+}
+
+type FileColors struct {
+	Primary   string `yaml:"primary,omitempty"`
+	Secondary string `yaml:"secondary,omitempty"`
+	Accent    string `yaml:"accent,omitempty"`
+}
+
+// readFile читает файл конфигурации. Неизвестные ключи считаются ошибкой,
+// чтобы опечатка не отключала настройку незаметно.
+func readFile(path string) (*FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file FileConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	for _, provider := range file.Providers {
+		if provider.Name == "" {
+			return nil, fmt.Errorf("config file %s: provider without name", path)
+		}
+	}
+	for _, site := range file.Sites {
+		if site.Name == "" {
+			return nil, fmt.Errorf("config file %s: site without name", path)
+		}
+	}
+	return &file, nil
+}
+
+// values переводит файл в переменные конфигурации, которые затем
+// дополняются .env и окружением
+func (f *FileConfig) values() map[string]string {
+	env := make(map[string]string)
+	str := func(key, value string) {
+		if value != "" {
+			env[key] = value
+		}
+	}
+	num := func(key string, value *int) {
+		if value != nil {
+			env[key] = strconv.Itoa(*value)
+		}
+	}
+	float := func(key string, value *float64) {
+		if value != nil {
+			env[key] = strconv.FormatFloat(*value, 'f', -1, 64)
+		}
+	}
+	list := func(key string, values []string) {
+		str(key, strings.Join(values, ","))
+	}
+
+	str("HOST", f.Server.Host)
+	str("PORT", f.Server.Port)
+	num("TIMEOUT", f.Server.Timeout)
+	num("HEALTH_CHECK_INTERVAL", f.Server.HealthCheckInterval)
+
+	num("MAX_TOKENS", f.AI.MaxTokens)
+	float("TEMPERATURE", f.AI.Temperature)
+	str("SYSTEM_PROMPT", f.AI.SystemPrompt)
+	num("RETRY_ATTEMPTS", f.AI.RetryAttempts)
+	num("CIRCUIT_BREAKER_THRESHOLD", f.AI.CircuitBreakerThreshold)
+	num("CIRCUIT_BREAKER_COOLDOWN", f.AI.CircuitBreakerCooldown)
+
+	names := make([]string, len(f.Providers))
+	for i, provider := range f.Providers {
+		names[i] = provider.Name
+		prefix := EnvPrefix(provider.Name)
+		str(prefix+"_TYPE", provider.Type)
+		str(prefix+"_URL", provider.URL)
+		str(prefix+"_API_KEY", provider.APIKey)
+		str(prefix+"_MODEL", provider.Model)
+		str(prefix+"_HEADERS", formatHeaders(provider.Headers))
+		num(prefix+"_PRIORITY", provider.Priority)
+	}
+	list("AI_PROVIDERS", names)
+
+	str("SESSION_STORE", f.Sessions.Store)
+	num("SESSION_TTL", f.Sessions.TTL)
+	str("SQLITE_PATH", f.Sessions.SQLitePath)
+
+	str("CONTEXT_STRATEGY", f.Context.Strategy)
+	num("CONTEXT_WINDOW", f.Context.Window)
+	num("DEFAULT_CONTEXT_LENGTH", f.Context.DefaultLength)
+	num("SUMMARY_THRESHOLD", f.Context.SummaryThreshold)
+	num("SUMMARY_KEEP_RECENT", f.Context.SummaryKeepRecent)
+
+	str("USAGE_FILE", f.Budget.UsageFile)
+	num("BUDGET_DAILY_TOKENS", f.Budget.DailyTokens)
+	float("BUDGET_DAILY_COST", f.Budget.DailyCost)
+	num("BUDGET_MONTHLY_TOKENS", f.Budget.MonthlyTokens)
+	float("BUDGET_MONTHLY_COST", f.Budget.MonthlyCost)
+	num("BUDGET_SOFT_PERCENT", f.Budget.SoftPercent)
+	str("BUDGET_FALLBACK_MODEL", f.Budget.FallbackModel)
+	str("BUDGET_MESSAGE", f.Budget.Message)
+
+	num("RATE_LIMIT_IP", f.RateLimit.IP)
+	num("RATE_LIMIT_IP_BURST", f.RateLimit.IPBurst)
+	num("RATE_LIMIT_CONVERSATION", f.RateLimit.Conversation)
+	num("RATE_LIMIT_CONVERSATION_BURST", f.RateLimit.ConversationBurst)
+	num("MAX_CONCURRENT", f.RateLimit.MaxConcurrent)
+	list("TRUSTED_PROXIES", f.RateLimit.TrustedProxies)
+
+	list("ALLOWED_ORIGINS", f.AllowedOrigins)
+
+	str("PROMPT_POLICY", f.Prompts.Policy)
+	str("PROMPTS_FILE", f.Prompts.File)
+	str("PROMPT_PRESET", f.Prompts.Preset)
+
+	str("ADMIN_PASSWORD_HASH", f.Admin.PasswordHash)
+	str("ADMIN_TOKEN", f.Admin.Token)
+
+	str("SECRETS_FILE", f.Secrets.File)
+	str("SECRETS_KEY_FILE", f.Secrets.KeyFile)
+
+	names = make([]string, len(f.Sites))
+	for i, site := range f.Sites {
+		names[i] = site.Name
+		prefix := SiteEnvPrefix(site.Name)
+		str(prefix+"_KEY", site.Key)
+		str(prefix+"_SYSTEM_PROMPT", site.SystemPrompt)
+		str(prefix+"_PROVIDER", site.Provider)
+		str(prefix+"_MODEL", site.Model)
+		list(prefix+"_ALLOWED_ORIGINS", site.AllowedOrigins)
+		str(prefix+"_PROMPT_POLICY", site.PromptPolicy)
+		str(prefix+"_PROMPT_PRESET", site.PromptPreset)
+		str(prefix+"_HEDGE_PROVIDER", site.Hedge.Provider)
+		str(prefix+"_HEDGE_MODEL", site.Hedge.Model)
+		if site.Hedge.DelayMs != 0 {
+			num(prefix+"_HEDGE_DELAY_MS", &site.Hedge.DelayMs)
+		}
+		num(prefix+"_RATE_LIMIT_IP", site.RateLimit.IP)
+		num(prefix+"_RATE_LIMIT_IP_BURST", site.RateLimit.IPBurst)
+		num(prefix+"_RATE_LIMIT_CONVERSATION", site.RateLimit.Conversation)
+		num(prefix+"_RATE_LIMIT_CONVERSATION_BURST", site.RateLimit.ConversationBurst)
+		str(prefix+"_PRIMARY_COLOR", site.Colors.Primary)
+		str(prefix+"_SECONDARY_COLOR", site.Colors.Secondary)
+		str(prefix+"_ACCENT_COLOR", site.Colors.Accent)
+		str(prefix+"_WELCOME", site.Welcome)
+		str(prefix+"_QUICK_BUTTONS", formatQuickButtons(site.QuickButtons))
+	}
+	list("SITES", names)
+
+	return env
+}
+
+// NewFileConfig переводит конфигурацию в схему файла, например для
+// вывода действующей конфигурации
+func NewFileConfig(cfg *Config) *FileConfig {
+	intp := func(value int) *int { return &value }
+	floatp := func(value float64) *float64 { return &value }
+
+	file := &FileConfig{
+		Server: FileServer{Host: cfg.Host, Port: cfg.Port, Timeout: intp(cfg.Timeout), HealthCheckInterval: intp(cfg.HealthCheckInterval)},
+		AI: FileAI{
+			MaxTokens:    intp(cfg.MaxTokens),
+			Temperature:  floatp(cfg.Temperature),
+			SystemPrompt: cfg.SystemPrompt,
+
+			RetryAttempts:           intp(cfg.RetryAttempts),
+			CircuitBreakerThreshold: intp(cfg.CircuitBreakerThreshold),
+			CircuitBreakerCooldown:  intp(cfg.CircuitBreakerCooldown),
+		},
+		Sessions: FileSessions{Store: cfg.SessionStore, TTL: intp(cfg.SessionTTL), SQLitePath: cfg.SQLitePath},
+		Context: FileContext{
+			Strategy:          cfg.ContextStrategy,
+			Window:            intp(cfg.ContextWindow),
+			DefaultLength:     intp(cfg.DefaultContextLength),
+			SummaryThreshold:  intp(cfg.SummaryThreshold),
+			SummaryKeepRecent: intp(cfg.SummaryKeepRecent),
+		},
+		Budget: FileBudget{
+			UsageFile:     cfg.UsageFile,
+			DailyTokens:   intp(cfg.BudgetDailyTokens),
+			DailyCost:     floatp(cfg.BudgetDailyCost),
+			MonthlyTokens: intp(cfg.BudgetMonthlyTokens),
+			MonthlyCost:   floatp(cfg.BudgetMonthlyCost),
+			SoftPercent:   intp(cfg.BudgetSoftPercent),
+			FallbackModel: cfg.BudgetFallbackModel,
+			Message:       cfg.BudgetMessage,
+		},
+		RateLimit: FileRateLimit{
+			IP:                intp(cfg.RateLimitIP),
+			IPBurst:           intp(cfg.RateLimitIPBurst),
+			Conversation:      intp(cfg.RateLimitConversation),
+			ConversationBurst: intp(cfg.RateLimitConversationBurst),
+			MaxConcurrent:     intp(cfg.MaxConcurrent),
+			TrustedProxies:    cfg.TrustedProxies,
+		},
+		AllowedOrigins: cfg.AllowedOrigins,
+		Prompts: FilePrompts{
+			Policy:  cfg.PromptPolicy,
+			File:    cfg.PromptsFile,
+			Preset:  cfg.PromptPreset,
+			Presets: cfg.PromptPresets,
+		},
+		Admin:   FileAdmin{PasswordHash: cfg.AdminPasswordHash, Token: cfg.AdminToken},
+		Secrets: FileSecrets{File: cfg.SecretsFile, KeyFile: cfg.SecretsKeyFile},
+	}
+
+	for _, provider := range cfg.Providers {
+		file.Providers = append(file.Providers, FileProvider{
+			Name:     provider.Name,
+			Type:     provider.Type,
+			URL:      provider.URL,
+			APIKey:   provider.APIKey,
+			Model:    provider.Model,
+			Headers:  provider.Headers,
+			Priority: intp(provider.Priority),
+		})
+	}
+
+	for _, site := range cfg.Sites {
+		file.Sites = append(file.Sites, FileSite{
+			Name:           site.Name,
+			Key:            site.Key,
+			SystemPrompt:   site.SystemPrompt,
+			Provider:       site.Provider,
+			Model:          site.Model,
+			AllowedOrigins: site.AllowedOrigins,
+			PromptPolicy:   site.PromptPolicy,
+			PromptPreset:   site.PromptPreset,
+			Hedge:          site.Hedge,
+			RateLimit: FileSiteLimit{
+				IP:                intp(site.RateLimitIP),
+				IPBurst:           intp(site.RateLimitIPBurst),
+				Conversation:      intp(site.RateLimitConversation),
+				ConversationBurst: intp(site.RateLimitConversationBurst),
+			},
+			Colors:       FileColors{Primary: site.PrimaryColor, Secondary: site.SecondaryColor, Accent: site.AccentColor},
+			Welcome:      site.Welcome,
+			QuickButtons: site.QuickButtons,
+		})
+	}
+	return file
+}
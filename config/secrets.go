@@ -0,0 +1,255 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// secretsHeader отличает файл секретов и связывает шифротекст с форматом
+const secretsHeader = "ai-bot-secrets-v1"
+
+// secretsIterations число итераций PBKDF2 для ключа из пароля
+const secretsIterations = 600000
+
+// SecretsUnlock способ открыть зашифрованный файл секретов: пароль или
+// файл ключа (случайные байты, см. NewSecretsKeyFile)
+type SecretsUnlock struct {
+	Passphrase string
+	KeyFile    string
+}
+
+// secretsFile содержимое файла секретов на диске
+type secretsFile struct {
+	Format string `json:"format"`
+	Salt   string `json:"salt"`
+	Nonce  string `json:"nonce"`
+	Data   string `json:"data"`
+}
+
+// key возвращает ключ AES-256 для файла с солью salt
+func (u SecretsUnlock) key(salt []byte) ([]byte, error) {
+	if u.KeyFile != "" {
+		data, err := os.ReadFile(u.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secrets key file: %w", err)
+		}
+		sum := sha256.Sum256(append(salt, data...))
+		return sum[:], nil
+	}
+	if u.Passphrase == "" {
+		return nil, errors.New("secrets file is locked: set SECRETS_PASSPHRASE, SECRETS_PASSPHRASE_FILE or SECRETS_KEY_FILE")
+	}
+	return pbkdf2.Key(sha256.New, u.Passphrase, salt, secretsIterations, 32)
+}
+
+// ReadSecrets расшифровывает файл секретов. Значения - переменные
+// конфигурации, например OPENROUTER_API_KEY.
+func ReadSecrets(path string, unlock SecretsUnlock) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %w", err)
+	}
+
+	var file secretsFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Format != secretsHeader {
+		return nil, fmt.Errorf("secrets file %s has unknown format", path)
+	}
+	salt, err := base64.StdEncoding.DecodeString(file.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("secrets file %s: invalid salt", path)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(file.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("secrets file %s: invalid nonce", path)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(file.Data)
+	if err != nil {
+		return nil, fmt.Errorf("secrets file %s: invalid data", path)
+	}
+
+	gcm, err := secretsCipher(unlock, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("secrets file %s: invalid nonce", path)
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(secretsHeader))
+	if err != nil {
+		return nil, fmt.Errorf("secrets file %s: wrong passphrase or key file", path)
+	}
+
+	values := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("secrets file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// WriteSecrets шифрует значения и записывает файл секретов с правами 0600.
+// Соль и nonce новые при каждой записи.
+func WriteSecrets(path string, unlock SecretsUnlock, values map[string]string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := secretsCipher(unlock, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(secretsFile{
+		Format: secretsHeader,
+		Salt:   base64.StdEncoding.EncodeToString(salt),
+		Nonce:  base64.StdEncoding.EncodeToString(nonce),
+		Data:   base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, []byte(secretsHeader))),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write secrets file: %w", err)
+	}
+	return os.Rename(tmpFile, path)
+}
+
+func secretsCipher(unlock SecretsUnlock, salt []byte) (cipher.AEAD, error) {
+	key, err := unlock.key(salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewSecretsKeyFile создает файл ключа со случайным содержимым (права 0600)
+func NewSecretsKeyFile(path string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create secrets key file: %w", err)
+	}
+	defer file.Close()
+	_, err = file.WriteString(base64.StdEncoding.EncodeToString(key) + "\n")
+	return err
+}
+
+// SaveSecrets записывает ключи API и токен админки из конфигурации в файл
+// секретов SecretsFile. Остальные значения файла сохраняются. После этого
+// Save не записывает эти ключи в .env.
+func SaveSecrets(cfg *Config, unlock SecretsUnlock) error {
+	values := make(map[string]string)
+	if _, err := os.Stat(cfg.SecretsFile); err == nil {
+		if values, err = ReadSecrets(cfg.SecretsFile, unlock); err != nil {
+			return err
+		}
+	}
+
+	_, all := cfg.EnvValues()
+	for _, key := range cfg.secretKeys() {
+		if all[key] != "" {
+			values[key] = all[key]
+		}
+	}
+	if err := WriteSecrets(cfg.SecretsFile, unlock, values); err != nil {
+		return err
+	}
+
+	cfg.secrets = values
+	return nil
+}
+
+// secretKeys переменные с ключами и токенами, которые не должны попадать
+// в .env, если используется файл секретов
+func (c *Config) secretKeys() []string {
+	keys := []string{"ADMIN_TOKEN"}
+	for _, provider := range c.Providers {
+		keys = append(keys, EnvPrefix(provider.Name)+"_API_KEY")
+	}
+	return keys
+}
+
+// isSecretKey проверяет, может ли переменная читаться из файла <ИМЯ>_FILE
+func isSecretKey(key string) bool {
+	return strings.HasSuffix(key, "_API_KEY") || key == "ADMIN_TOKEN" || key == "ADMIN_PASSWORD_HASH" ||
+		key == "SECRETS_PASSPHRASE"
+}
+
+// readSecretFiles подставляет значения секретов из файлов <ИМЯ>_FILE
+// (секреты Docker и Kubernetes)
+func readSecretFiles(env *environment) {
+	names := make([]string, 0, len(env.values))
+	for name := range env.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key, ok := strings.CutSuffix(name, "_FILE")
+		if !ok || !isSecretKey(key) || env.values[name] == "" {
+			continue
+		}
+		if env.values[key] != "" {
+			env.problems = append(env.problems, fmt.Sprintf("%s and %s: set only one of them", key, name))
+			continue
+		}
+		data, err := os.ReadFile(env.values[name])
+		if err != nil {
+			env.problems = append(env.problems, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		value := strings.TrimRight(string(data), "\r\n")
+		env.values[key] = value
+		env.external[key] = value
+	}
+}
+
+// readSecretsFile дополняет переменные значениями из зашифрованного файла
+// секретов SECRETS_FILE. Переменные окружения процесса имеют приоритет.
+func readSecretsFile(env *environment) error {
+	path := env.values["SECRETS_FILE"]
+	if path == "" {
+		return nil
+	}
+	values, err := ReadSecrets(path, SecretsUnlock{
+		Passphrase: env.values["SECRETS_PASSPHRASE"],
+		KeyFile:    env.values["SECRETS_KEY_FILE"],
+	})
+	if err != nil {
+		return err
+	}
+	for key, value := range values {
+		if _, ok := processEnv[key]; ok {
+			continue
+		}
+		env.values[key] = value
+		env.secrets[key] = value
+	}
+	return nil
+}
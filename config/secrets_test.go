@@ -0,0 +1,115 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretsRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "secrets.key")
+	if err := NewSecretsKeyFile(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{"OPENAI_API_KEY": "sk-test", "ADMIN_TOKEN": "token\nwith newline"}
+
+	for name, unlock := range map[string]SecretsUnlock{
+		"passphrase": {Passphrase: "correct horse"},
+		"key file":   {KeyFile: keyFile},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name+".enc")
+			if err := WriteSecrets(path, unlock, values); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadSecrets(path, unlock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, values) {
+				t.Errorf("ReadSecrets() = %v, want %v", got, values)
+			}
+		})
+	}
+}
+
+func TestSecretsWrongPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	if err := WriteSecrets(path, SecretsUnlock{Passphrase: "right"}, map[string]string{"OPENAI_API_KEY": "sk-test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSecrets(path, SecretsUnlock{Passphrase: "wrong"}); err == nil {
+		t.Fatal("ReadSecrets() with a wrong passphrase succeeded")
+	}
+	if _, err := ReadSecrets(path, SecretsUnlock{}); err == nil {
+		t.Fatal("ReadSecrets() without a passphrase succeeded")
+	}
+}
+
+func TestLoadReadsSecretsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	unlock := SecretsUnlock{Passphrase: "passphrase"}
+	if err := WriteSecrets(path, unlock, map[string]string{"OPENAI_API_KEY": "sk-secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := loadEnv(t, map[string]string{
+		"AI_PROVIDERS":       "openai",
+		"SECRETS_FILE":       path,
+		"SECRETS_PASSPHRASE": unlock.Passphrase,
+	})
+	if got := cfg.Provider("openai").APIKey; got != "sk-secret" {
+		t.Errorf("OPENAI_API_KEY = %q, want the value from the secrets file", got)
+	}
+
+	// Ключ из файла секретов не попадает в .env
+	cfg.MaxTokens = 1000
+	if err := Save(cfg); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(EnvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "sk-secret") {
+		t.Errorf(".env contains the key from the secrets file: %q", data)
+	}
+}
+
+func TestSaveMovesSecretsToEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	unlock := SecretsUnlock{Passphrase: "passphrase"}
+	if err := WriteSecrets(path, unlock, map[string]string{"OPENAI_API_KEY": "sk-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile(EnvFile, []byte("AI_PROVIDERS=openai\nSECRETS_FILE="+path+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	processEnv = map[string]string{"SECRETS_PASSPHRASE": unlock.Passphrase}
+	t.Cleanup(func() { processEnv = environ() })
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Хранение ключей переключено на .env, как в конфигураторе
+	cfg.SecretsFile = ""
+	if err := Save(cfg); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(EnvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "OPENAI_API_KEY=sk-secret\n") {
+		t.Errorf(".env = %q, want the key from the secrets file", data)
+	}
+	if strings.Contains(string(data), "SECRETS_FILE") {
+		t.Errorf(".env = %q, still refers to the secrets file", data)
+	}
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"ai-bot/ai"
+	"ai-bot/ratelimit"
+)
+
+// ValidationError все ошибки конфигурации, найденные Validate
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// validator собирает ошибки конфигурации, чтобы сообщить обо всех сразу
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) positive(key string, value int) {
+	if value <= 0 {
+		v.addf("%s=%d: must be greater than 0", key, value)
+	}
+}
+
+func (v *validator) nonNegative(key string, value float64) {
+	if value < 0 {
+		v.addf("%s=%v: must not be negative (0 - no limit)", key, value)
+	}
+}
+
+func (v *validator) oneOf(key, value string, options ...string) {
+	for _, option := range options {
+		if value == option {
+			return
+		}
+	}
+	v.addf("%s=%q: must be one of %s", key, value, strings.Join(options, ", "))
+}
+
+// url проверяет адрес API провайдера
+func (v *validator) url(key, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.addf("%s=%q: must be an http:// or https:// URL", key, value)
+	}
+}
+
+// origins проверяет список разрешенных сайтов: "*", "https://example.com"
+// или "https://*.example.com"
+func (v *validator) origins(key string, origins []string) {
+	for _, origin := range origins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+			v.addf("%s: %q must look like https://example.com or https://*.example.com", key, origin)
+		}
+	}
+}
+
+func (v *validator) rateLimits(prefix string, ip, ipBurst, conversation, conversationBurst int) {
+	v.nonNegative(prefix+"RATE_LIMIT_IP", float64(ip))
+	v.nonNegative(prefix+"RATE_LIMIT_IP_BURST", float64(ipBurst))
+	v.nonNegative(prefix+"RATE_LIMIT_CONVERSATION", float64(conversation))
+	v.nonNegative(prefix+"RATE_LIMIT_CONVERSATION_BURST", float64(conversationBurst))
+}
+
+// Validate проверяет типы и диапазоны значений, адреса и согласованность
+// провайдеров и сайтов. Возвращает *ValidationError со всеми найденными
+// ошибками или nil.
+func (c *Config) Validate() error {
+	v := &validator{problems: append([]string(nil), c.problems...)}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		v.addf("PORT=%q: must be a number from 1 to 65535", c.Port)
+	}
+	v.positive("MAX_TOKENS", c.MaxTokens)
+	if c.Temperature < 0 || c.Temperature > 2 {
+		v.addf("TEMPERATURE=%v: must be from 0 to 2", c.Temperature)
+	}
+	v.positive("TIMEOUT", c.Timeout)
+	v.positive("HEALTH_CHECK_INTERVAL", c.HealthCheckInterval)
+	v.nonNegative("RETRY_ATTEMPTS", float64(c.RetryAttempts))
+	v.nonNegative("CIRCUIT_BREAKER_THRESHOLD", float64(c.CircuitBreakerThreshold))
+	v.positive("CIRCUIT_BREAKER_COOLDOWN", c.CircuitBreakerCooldown)
+
+	v.positive("SESSION_TTL", c.SessionTTL)
+	v.oneOf("SESSION_STORE", c.SessionStore, "memory", "sqlite")
+	if c.SessionStore == "sqlite" && c.SQLitePath == "" {
+		v.addf("SQLITE_PATH: required for SESSION_STORE=sqlite")
+	}
+
+	v.oneOf("CONTEXT_STRATEGY", c.ContextStrategy, "drop-oldest", "sliding-window", "summarize")
+	v.positive("CONTEXT_WINDOW", c.ContextWindow)
+	v.positive("DEFAULT_CONTEXT_LENGTH", c.DefaultContextLength)
+	v.positive("SUMMARY_THRESHOLD", c.SummaryThreshold)
+	v.nonNegative("SUMMARY_KEEP_RECENT", float64(c.SummaryKeepRecent))
+
+	v.nonNegative("BUDGET_DAILY_TOKENS", float64(c.BudgetDailyTokens))
+	v.nonNegative("BUDGET_DAILY_COST", c.BudgetDailyCost)
+	v.nonNegative("BUDGET_MONTHLY_TOKENS", float64(c.BudgetMonthlyTokens))
+	v.nonNegative("BUDGET_MONTHLY_COST", c.BudgetMonthlyCost)
+	if c.BudgetSoftPercent < 0 || c.BudgetSoftPercent > 100 {
+		v.addf("BUDGET_SOFT_PERCENT=%d: must be from 0 to 100", c.BudgetSoftPercent)
+	}
+
+	v.rateLimits("", c.RateLimitIP, c.RateLimitIPBurst, c.RateLimitConversation, c.RateLimitConversationBurst)
+	v.nonNegative("MAX_CONCURRENT", float64(c.MaxConcurrent))
+	for _, proxy := range c.TrustedProxies {
+		if _, err := ratelimit.ParseCIDRs([]string{proxy}); err != nil {
+			v.addf("TRUSTED_PROXIES: %v", err)
+		}
+	}
+	v.origins("ALLOWED_ORIGINS", c.AllowedOrigins)
+	v.oneOf("PROMPT_POLICY", c.PromptPolicy, PromptPolicyDeny, PromptPolicyPresets, PromptPolicyAppend)
+
+	// Провайдеры: без модели запрос к провайдеру невозможен
+	providers := make(map[string]bool, len(c.Providers))
+	for _, provider := range c.Providers {
+		prefix := EnvPrefix(provider.Name)
+		if providers[provider.Name] {
+			v.addf("AI_PROVIDERS: provider %q is listed twice", provider.Name)
+		}
+		providers[provider.Name] = true
+		v.oneOf(prefix+"_TYPE", strings.ToLower(provider.Type), ai.ProviderTypes()...)
+		v.url(prefix+"_URL", provider.URL)
+		if provider.Model == "" {
+			v.addf("%s_MODEL: model is required for provider %q", prefix, provider.Name)
+		}
+	}
+	if len(c.Providers) == 0 {
+		v.addf("AI_PROVIDERS: at least one provider is required")
+	}
+
+	keys := make(map[string]string, len(c.Sites))
+	for _, site := range c.Sites {
+		prefix := SiteEnvPrefix(site.Name)
+		if site.Key == "" {
+			v.addf("%s_KEY: key is required", prefix)
+		} else if other, exists := keys[site.Key]; exists {
+			v.addf("%s_KEY: key %q is already used by site %q", prefix, site.Key, other)
+		}
+		keys[site.Key] = site.Name
+		if site.Provider != "" && !providers[site.Provider] {
+			v.addf("%s_PROVIDER=%q: provider is not listed in AI_PROVIDERS", prefix, site.Provider)
+		}
+		if site.Hedge.Provider != "" && !providers[site.Hedge.Provider] {
+			v.addf("%s_HEDGE_PROVIDER=%q: provider is not listed in AI_PROVIDERS", prefix, site.Hedge.Provider)
+		}
+		v.nonNegative(prefix+"_HEDGE_DELAY_MS", float64(site.Hedge.DelayMs))
+		v.origins(prefix+"_ALLOWED_ORIGINS", site.AllowedOrigins)
+		v.oneOf(prefix+"_PROMPT_POLICY", site.PromptPolicy, PromptPolicyDeny, PromptPolicyPresets, PromptPolicyAppend)
+		v.rateLimits(prefix+"_", site.RateLimitIP, site.RateLimitIPBurst, site.RateLimitConversation, site.RateLimitConversationBurst)
+	}
+
+	if c.SecretsKeyFile != "" && c.SecretsFile == "" {
+		v.addf("SECRETS_KEY_FILE: SECRETS_FILE is not set")
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
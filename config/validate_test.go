@@ -0,0 +1,85 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	valid := map[string]string{"AI_PROVIDERS": "openai", "OPENAI_API_KEY": "sk-test"}
+	tests := []struct {
+		name string
+		env  map[string]string
+		edit func(*Config)
+		want []string
+	}{
+		{
+			name: "valid",
+			env:  valid,
+		},
+		{
+			name: "unparsed values",
+			env:  map[string]string{"AI_PROVIDERS": "openai", "PORT": "http", "MAX_TOKENS": "many"},
+			want: []string{"PORT=", "MAX_TOKENS"},
+		},
+		{
+			name: "several settings",
+			env:  valid,
+			edit: func(c *Config) {
+				c.Temperature = 3
+				c.SessionStore = "redis"
+				c.ContextWindow = 0
+				c.BudgetSoftPercent = 120
+			},
+			want: []string{"TEMPERATURE", "SESSION_STORE", "CONTEXT_WINDOW", "BUDGET_SOFT_PERCENT"},
+		},
+		{
+			name: "providers",
+			env:  map[string]string{"AI_PROVIDERS": "openai,custom", "CUSTOM_TYPE": "gemini", "CUSTOM_URL": "ftp://host", "CUSTOM_MODEL": "m"},
+			edit: func(c *Config) { c.Provider("openai").Model = "" },
+			want: []string{"OPENAI_MODEL", "CUSTOM_TYPE", "CUSTOM_URL"},
+		},
+		{
+			name: "sites",
+			env: map[string]string{
+				"AI_PROVIDERS":              "openai",
+				"SITES":                     "shop,blog",
+				"SITE_SHOP_KEY":             "same",
+				"SITE_SHOP_PROVIDER":        "anthropic",
+				"SITE_SHOP_HEDGE_PROVIDER":  "ollama",
+				"SITE_BLOG_KEY":             "same",
+				"SITE_BLOG_ALLOWED_ORIGINS": "example.com",
+			},
+			want: []string{"SITE_SHOP_PROVIDER", "SITE_SHOP_HEDGE_PROVIDER", "SITE_BLOG_KEY", "SITE_BLOG_ALLOWED_ORIGINS"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadEnv(t, tt.env)
+			if tt.edit != nil {
+				tt.edit(cfg)
+			}
+			err := cfg.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+
+			var validation *ValidationError
+			if !errors.As(err, &validation) {
+				t.Fatalf("Validate() = %v, want *ValidationError", err)
+			}
+			if len(validation.Problems) < len(tt.want) {
+				t.Errorf("got %d problems, want at least %d: %q", len(validation.Problems), len(tt.want), validation.Problems)
+			}
+			for _, key := range tt.want {
+				if !strings.Contains(err.Error(), key) {
+					t.Errorf("problem %s is not reported:\n%v", key, err)
+				}
+			}
+		})
+	}
+}
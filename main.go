@@ -4,39 +4,55 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"ai-bot/ai"
 	"ai-bot/config"
+	"ai-bot/store"
+	"ai-bot/usage"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/term"
+	"gopkg.in/yaml.v3"
 )
 
 var (
 	host            = flag.String("host", "", "Host to bind server (overrides .env)")
 	port            = flag.String("port", "", "Port to bind server (overrides .env)")
-	openRouterKey   = flag.String("openrouter-key", "", "OpenRouter API key (overrides .env)")
+	openRouterKey   = flag.String("openrouter-key", "", "OpenRouter API key (overrides .env), - to read from stdin")
 	openRouterModel = flag.String("openrouter-model", "", "OpenRouter model (overrides .env)")
-	openAIKey       = flag.String("openai-key", "", "OpenAI API key (overrides .env)")
+	openAIKey       = flag.String("openai-key", "", "OpenAI API key (overrides .env), - to read from stdin")
 	openAIModel     = flag.String("openai-model", "", "OpenAI model (overrides .env)")
 	maxTokens       = flag.Int("max-tokens", 0, "Maximum tokens (overrides .env)")
-	temperature     = flag.Float64("temperature", -1, "Temperature 0-1 (overrides .env)")
+	temperature     = flag.Float64("temperature", -1, "Temperature 0-2 (overrides .env)")
 	timeout         = flag.Int("timeout", 0, "Request timeout in seconds (overrides .env)")
 	configCmd       = flag.Bool("config", false, "Run configuration wizard")
 	demoOnly        = flag.Bool("demo", false, "Show demo page on main route (/)")
+	hashPasswordCmd = flag.Bool("hash-password", false, "Read admin password from stdin and print ADMIN_PASSWORD_HASH")
+	configFile      = flag.String("config-file", "", "YAML config file (overridden by .env, environment and flags)")
+	printConfigCmd  = flag.Bool("print-config", false, "Print effective configuration with masked secrets")
+	checkConfigCmd  = flag.Bool("check-config", false, "Validate configuration and exit with non-zero status on errors")
 )
 
 func main() {
 	flag.Parse()
+	config.File = *configFile
+	if err := readFlagSecrets(); err != nil {
+		log.Fatalf("Ошибка: %v", err)
+	}
 
 	// Если указан флаг config, запускаем конфигуратор
 	if *configCmd {
@@ -44,71 +60,54 @@ func main() {
 		return
 	}
 
-	// Загружаем конфигурацию из .env
-	cfg, err := config.Load()
-	if err != nil {
-		log.Printf("Предупреждение: не удалось загрузить .env файл: %v", err)
-		cfg = &config.Config{
-			Host:            "0.0.0.0",
-			Port:            "8080",
-			OpenRouterModel: "anthropic/claude-3.5-sonnet",
-			OpenAIModel:     "gpt-4o",
-			MaxTokens:       4000,
-			Temperature:     0.3,
-			Timeout:         30,
-		}
+	if *hashPasswordCmd {
+		runHashPassword()
+		return
 	}
 
-	// Переопределяем значения из командной строки
-	if *host != "" {
-		cfg.Host = *host
-	}
-	if *port != "" {
-		cfg.Port = *port
-	}
-	if *openRouterKey != "" {
-		cfg.OpenRouterKey = *openRouterKey
-	}
-	if *openRouterModel != "" {
-		cfg.OpenRouterModel = *openRouterModel
-	}
-	if *openAIKey != "" {
-		cfg.OpenAIKey = *openAIKey
-	}
-	if *openAIModel != "" {
-		cfg.OpenAIModel = *openAIModel
+	if *printConfigCmd {
+		runPrintConfig()
+		return
 	}
-	if *maxTokens > 0 {
-		cfg.MaxTokens = *maxTokens
+
+	if *checkConfigCmd {
+		runCheckConfig()
+		return
 	}
-	if *temperature >= 0 {
-		cfg.Temperature = *temperature
+
+	// Загружаем конфигурацию: файл конфигурации, .env, окружение и аргументы
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Ошибка конфигурации: %v\nПроверить настройки: ./ai-bot.exe --check-config", err)
 	}
-	if *timeout > 0 {
-		cfg.Timeout = *timeout
+
+	// Учет расхода токенов и стоимости по дням, сохраняется между перезапусками
+	tracker, err := usage.NewTracker(cfg.UsageFile)
+	if err != nil {
+		log.Fatalf("Ошибка статистики расхода: %v", err)
 	}
 
-	// Создаем конфигурацию AI
-	aiConfig := &ai.Config{
-		OpenRouterAPIKey: cfg.OpenRouterKey,
-		OpenRouterModel:  cfg.OpenRouterModel,
-		OpenRouterURL:    "https://openrouter.ai/api/v1",
-		OpenAIAPIKey:     cfg.OpenAIKey,
-		OpenAIModel:      cfg.OpenAIModel,
-		MaxTokens:        cfg.MaxTokens,
-		Temperature:      float32(cfg.Temperature),
-		RequestTimeout:   cfg.Timeout,
-	}
-
-	// Проверяем наличие хотя бы одного API ключа
-	if aiConfig.OpenRouterAPIKey == "" && aiConfig.OpenAIAPIKey == "" {
-		log.Fatal("Ошибка: необходимо указать хотя бы один API ключ")
-		log.Fatal("Используйте: ./ai-bot.exe --config")
+	// Клиент AI, промпты, лимиты и сайты. При изменении .env они строятся
+	// заново и заменяют текущие, см. configManager
+	state, err := newAppState(cfg, tracker, nil)
+	if errors.Is(err, errNoProviders) {
+		log.Println("Ошибка: необходимо указать хотя бы один API ключ или локальный провайдер (AI_PROVIDERS=ollama)")
+		log.Println("Используйте: ./ai-bot.exe --config")
 		log.Fatal("Или укажите ключ в .env файле или через аргументы командной строки")
 	}
+	if err != nil {
+		log.Fatalf("Ошибка конфигурации: %v", err)
+	}
+	state.health.Start()
+	manager := newConfigManager(state, tracker, loadConfig)
+	go manager.Watch()
 
-	// Создаем AI клиент
-	client := ai.NewClient(aiConfig)
+	// Хранилище разговоров
+	sessions, err := newSessionStore(cfg)
+	if err != nil {
+		log.Fatalf("Ошибка хранилища разговоров: %v", err)
+	}
+	defer sessions.Close()
 
 	// Настраиваем маршруты
 	if *demoOnly {
@@ -138,13 +137,49 @@ func main() {
 		})
 	}
 
-	http.HandleFunc("/api/chat", func(w http.ResponseWriter, r *http.Request) {
-		handleChat(w, r, client, aiConfig)
-	})
-
-	http.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
-		handleStatus(w, r, client)
-	})
+	http.HandleFunc("/api/chat", manager.Wrap(func(w http.ResponseWriter, r *http.Request, state *appState) {
+		state.sites.Wrap(state.limiter.Wrap(func(w http.ResponseWriter, r *http.Request) {
+			handleChat(w, r, state, sessions, tracker)
+		}))(w, r)
+	}))
+
+	// Потоковый ответ (Server-Sent Events)
+	http.HandleFunc("/api/chat/stream", manager.Wrap(func(w http.ResponseWriter, r *http.Request, state *appState) {
+		state.sites.Wrap(state.limiter.Wrap(func(w http.ResponseWriter, r *http.Request) {
+			handleChat(w, r, state, sessions, tracker)
+		}))(w, r)
+	}))
+
+	// История разговора для восстановления чата в виджете
+	http.HandleFunc("/api/conversation", manager.Wrap(func(w http.ResponseWriter, r *http.Request, state *appState) {
+		state.sites.Wrap(func(w http.ResponseWriter, r *http.Request) {
+			handleConversation(w, r, sessions)
+		})(w, r)
+	}))
+
+	http.HandleFunc("/api/status", manager.Wrap(func(w http.ResponseWriter, r *http.Request, state *appState) {
+		state.sites.Wrap(func(w http.ResponseWriter, r *http.Request) {
+			handleStatus(w, r, state)
+		})(w, r)
+	}))
+
+	// Проверки для оркестраторов (Kubernetes, Docker): процесс жив и
+	// есть хотя бы один доступный провайдер AI
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", manager.Wrap(handleReadyz))
+
+	// Админка: статус, расход и настройки, доступ только по паролю или токену
+	admin, err := newAdminHandler(cfg, manager, tracker, sessions)
+	if err != nil {
+		log.Fatalf("Ошибка настройки админки: %v", err)
+	}
+	if admin != nil {
+		http.HandleFunc("/admin", admin.Wrap(admin.Dashboard))
+		http.HandleFunc("/admin/login", admin.Login)
+		http.HandleFunc("/admin/logout", admin.Wrap(admin.Logout))
+		http.HandleFunc("/admin/settings", admin.Wrap(admin.Settings))
+		http.HandleFunc("/admin/stats", admin.Wrap(admin.Stats))
+	}
 
 	// Статические файлы (JS)
 	http.HandleFunc("/static/ai-bot.js", func(w http.ResponseWriter, r *http.Request) {
@@ -153,9 +188,9 @@ func main() {
 	})
 
 	// Встроенный чат - один тег script
-	http.HandleFunc("/chat.js", func(w http.ResponseWriter, r *http.Request) {
-		serveEmbeddedChatNew(w, r)
-	})
+	http.HandleFunc("/chat.js", manager.Wrap(func(w http.ResponseWriter, r *http.Request, state *appState) {
+		serveEmbeddedChatNew(w, r, state.sites)
+	}))
 
 	addr := fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
 	log.Printf("AI Bot сервер запущен на http://%s", addr)
@@ -163,19 +198,174 @@ func main() {
 		log.Printf("  Режим: Демо страница (--demo)")
 	}
 	log.Printf("Конфигурация:")
-	log.Printf("  OpenRouter: %s", maskKey(cfg.OpenRouterKey))
-	log.Printf("  OpenAI: %s", maskKey(cfg.OpenAIKey))
-	if cfg.OpenRouterKey != "" {
-		log.Printf("  Модель: %s", cfg.OpenRouterModel)
-	} else if cfg.OpenAIKey != "" {
-		log.Printf("  Модель: %s", cfg.OpenAIModel)
+	log.Printf("  Хранилище разговоров: %s", cfg.SessionStore)
+	log.Printf("  Сокращение истории: %s", cfg.ContextStrategy)
+	log.Printf("  Ограничения: %d запросов/мин с IP, %d запросов/мин в разговоре, %d одновременно (0 - без ограничения)",
+		cfg.RateLimitIP, cfg.RateLimitConversation, cfg.MaxConcurrent)
+	if len(cfg.AllowedOrigins) > 0 {
+		log.Printf("  Разрешенные сайты: %s", strings.Join(cfg.AllowedOrigins, ", "))
+	} else {
+		log.Printf("  Разрешенные сайты: любые (задайте ALLOWED_ORIGINS, чтобы ограничить)")
+	}
+	presetIDs := make([]string, len(state.prompts.Presets()))
+	for i, preset := range state.prompts.Presets() {
+		presetIDs[i] = preset.ID
+	}
+	if admin != nil {
+		log.Printf("  Админка: http://%s/admin", addr)
+	} else {
+		log.Printf("  Админка: отключена (задайте ADMIN_PASSWORD_HASH или ADMIN_TOKEN)")
+	}
+	log.Printf("  Промпты клиентов: %s, пресеты: %s", cfg.PromptPolicy, strings.Join(presetIDs, ", "))
+	for _, site := range cfg.Sites {
+		found, _ := state.sites.Lookup(site.Key)
+		log.Printf("  Сайт %s: %s", site.Name, found.chatClient(state.client).GetProvider())
+	}
+	if state.budget.Enabled() {
+		log.Printf("  Бюджет: в день %d токенов / $%.2f, в месяц %d токенов / $%.2f (0 - без лимита)",
+			cfg.BudgetDailyTokens, cfg.BudgetDailyCost, cfg.BudgetMonthlyTokens, cfg.BudgetMonthlyCost)
+	}
+	for _, provider := range state.client.Providers() {
+		p := cfg.Provider(provider.Name())
+		log.Printf("  %s: ключ %s, модель %s", provider.Name(), maskKey(p.APIKey), provider.Model())
+	}
+
+	if config.File != "" {
+		log.Printf("  Файл конфигурации: %s", config.File)
 	}
+	log.Printf("  Перезагрузка конфигурации: при изменении файлов конфигурации, по сигналу SIGHUP")
 
 	if err := http.ListenAndServe(addr, nil); err != nil {
 		log.Fatal("Ошибка запуска сервера:", err)
 	}
 }
 
+// loadConfig загружает конфигурацию, применяет аргументы командной строки
+// и проверяет результат
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	applyFlags(cfg)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyFlags переопределяет значения из командной строки
+func applyFlags(cfg *config.Config) {
+	if *host != "" {
+		cfg.Host = *host
+	}
+	if *port != "" {
+		cfg.Port = *port
+	}
+	if *openRouterKey != "" {
+		cfg.EnsureProvider("openrouter").APIKey = *openRouterKey
+	}
+	if *openRouterModel != "" {
+		cfg.EnsureProvider("openrouter").Model = *openRouterModel
+	}
+	if *openAIKey != "" {
+		cfg.EnsureProvider("openai").APIKey = *openAIKey
+	}
+	if *openAIModel != "" {
+		cfg.EnsureProvider("openai").Model = *openAIModel
+	}
+	if *maxTokens > 0 {
+		cfg.MaxTokens = *maxTokens
+	}
+	if *temperature >= 0 {
+		cfg.Temperature = *temperature
+	}
+	if *timeout > 0 {
+		cfg.Timeout = *timeout
+	}
+}
+
+// readFlagSecrets читает ключи API, переданные как --openrouter-key=-, из
+// стандартного ввода. Ключ в аргументах командной строки виден другим
+// пользователям в списке процессов.
+func readFlagSecrets() error {
+	var reader *bufio.Reader
+	for _, key := range []struct {
+		provider string
+		value    *string
+	}{{"openrouter", openRouterKey}, {"openai", openAIKey}} {
+		switch *key.value {
+		case "":
+		case "-":
+			if reader == nil {
+				reader = bufio.NewReader(os.Stdin)
+			}
+			*key.value = strings.TrimSpace(readSecret(reader, fmt.Sprintf("Ключ API %s: ", key.provider)))
+			if *key.value == "" {
+				return fmt.Errorf("--%s-key=-: no key on stdin", key.provider)
+			}
+		default:
+			log.Printf("⚠️  Ключ из --%s-key виден в списке процессов, используйте --%s-key=- или %s_API_KEY_FILE",
+				key.provider, key.provider, config.EnvPrefix(key.provider))
+		}
+	}
+	return nil
+}
+
+// readSecret читает строку без отображения ввода, если ввод идет с
+// терминала. Из канала или файла строка читается через reader.
+func readSecret(reader *bufio.Reader, prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	if term.IsTerminal(os.Stdin.Fd()) {
+		secret, err := term.ReadPassword(os.Stdin.Fd())
+		fmt.Fprintln(os.Stderr)
+		if err == nil {
+			return string(secret)
+		}
+	}
+	line, _ := reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
+
+// newAIConfig преобразует конфигурацию приложения в конфигурацию AI клиента
+func newAIConfig(cfg *config.Config) *ai.Config {
+	providers := make([]ai.ProviderConfig, len(cfg.Providers))
+	for i, p := range cfg.Providers {
+		providers[i] = ai.ProviderConfig{
+			Name:     p.Name,
+			Type:     p.Type,
+			BaseURL:  p.URL,
+			APIKey:   p.APIKey,
+			Model:    p.Model,
+			Headers:  p.Headers,
+			Priority: p.Priority,
+		}
+	}
+
+	return &ai.Config{
+		Providers:      providers,
+		MaxTokens:      cfg.MaxTokens,
+		Temperature:    float32(cfg.Temperature),
+		RequestTimeout: cfg.Timeout,
+
+		Retries:          cfg.RetryAttempts,
+		BreakerThreshold: cfg.CircuitBreakerThreshold,
+		BreakerCooldown:  cfg.CircuitBreakerCooldown,
+	}
+}
+
+// newSessionStore создает хранилище разговоров, выбранное в конфигурации
+func newSessionStore(cfg *config.Config) (store.SessionStore, error) {
+	ttl := time.Duration(cfg.SessionTTL) * time.Minute
+	switch cfg.SessionStore {
+	case "", "memory":
+		return store.NewMemoryStore(ttl), nil
+	case "sqlite":
+		return store.NewSQLiteStore(cfg.SQLitePath, ttl)
+	}
+	return nil, fmt.Errorf("unknown session store %q (available: memory, sqlite)", cfg.SessionStore)
+}
+
 func maskKey(key string) string {
 	if key == "" {
 		return "не указан"
@@ -494,10 +684,10 @@ func serveDemoPage(w http.ResponseWriter, r *http.Request) {
 
         <div class="demo-section">
             <h2>🤖 Кастомный системный промпт</h2>
-            <p>Настройте поведение AI под ваши задачи:</p>
+            <p>Выберите один из промптов, настроенных на сервере:</p>
             <div class="code-block">
 &lt;script src="` + baseURL + `/chat.js"
-        data-system-prompt="Ты дружелюбный помощник программиста. Объясняй код простыми словами и добавляй эмодзи."&gt;&lt;/script&gt;
+        data-prompt-preset="mentor"&gt;&lt;/script&gt;
             </div>
         </div>
 
@@ -508,7 +698,7 @@ func serveDemoPage(w http.ResponseWriter, r *http.Request) {
 &lt;script src="` + baseURL + `/chat.js"
         data-primary-color="#2c3e50"
         data-secondary-color="#34495e"
-        data-system-prompt="Ты профессиональный консультант. Давай четкие ответы."
+        data-prompt-preset="consultant"
         data-custom-css=".ai-chat-toggle{border:3px solid gold;}"&gt;&lt;/script&gt;
             </div>
         </div>
@@ -793,8 +983,8 @@ func servePromptDemoPage(w http.ResponseWriter, r *http.Request) {
 
         <div class="demo-section">
             <h2>🤖 Кастомный системный промпт</h2>
-            <p>Этот чат настроен как дружелюбный помощник программиста.</p>
-            <p>Он будет объяснять код простыми словами, давать советы и использовать эмодзи.</p>
+            <p>Этот чат использует пресет промпта <strong>mentor</strong> - опытный программист-наставник.</p>
+            <p>Он будет помогать с кодом, объяснять концепции и предлагать лучшие практики.</p>
         </div>
 
         <div class="demo-section">
@@ -808,11 +998,10 @@ func servePromptDemoPage(w http.ResponseWriter, r *http.Request) {
         <div class="demo-section">
             <h2>⚙️ Настройка промпта</h2>
             <div class="code-block">
-data-system-prompt="Ты дружелюбный помощник программиста. 
-Объясняй код простыми словами, давай практические советы, 
-используй эмодзи для наглядности. Будь терпеливым и 
-поддерживающим, особенно с новичками."
+data-prompt-preset="mentor"
             </div>
+            <p>Текст пресетов хранится на сервере (PROMPT_PRESETS), виджет выбирает пресет по id.
+            Произвольный промпт из data-system-prompt учитывается только при PROMPT_POLICY=append.</p>
         </div>
 
         <div class="demo-section">
@@ -832,7 +1021,7 @@ data-system-prompt="Ты дружелюбный помощник програм
             data-primary-color="#3498db"
             data-secondary-color="#2980b9"
             data-accent-color="#e74c3c"
-            data-system-prompt="Ты дружелюбный помощник программиста. Объясняй код простыми словами, давай практические советы, используй эмодзи для наглядности. Будь терпеливым и поддерживающим, особенно с новичками."></script>
+            data-prompt-preset="mentor"></script>
 </body>
 </html>`
 
@@ -840,30 +1029,92 @@ data-system-prompt="Ты дружелюбный помощник програм
 	fmt.Fprint(w, tmpl)
 }
 
-func handleChat(w http.ResponseWriter, r *http.Request, client *ai.Client, aiConfig *ai.Config) {
+// maxMessageLength максимальная длина сообщения пользователя в символах
+const maxMessageLength = 4000
+
+func handleChat(w http.ResponseWriter, r *http.Request, state *appState, sessions store.SessionStore, tracker *usage.Tracker) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	// Идентификатор связывает ответ с ошибкой и запись в логе сервера
+	requestID := responseRequestID(w)
+
 	var req struct {
-		Message      string           `json:"message"`
-		History      []ai.ChatMessage `json:"history"`
-		SystemPrompt string           `json:"systemPrompt,omitempty"`
+		Message        string `json:"message"`
+		ConversationID string `json:"conversation_id,omitempty"`
+		SystemPrompt   string `json:"systemPrompt,omitempty"`
+		Preset         string `json:"preset,omitempty"`
+		Language       string `json:"language,omitempty"`
+		PageURL        string `json:"page_url,omitempty"`
+		PageTitle      string `json:"page_title,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		writeChatError(w, requestID, chatError{Code: codeInvalidRequest, Message: "Некорректный запрос.", status: http.StatusBadRequest})
+		return
+	}
+
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" || utf8.RuneCountInString(req.Message) > maxMessageLength {
+		writeChatError(w, requestID, chatError{Code: codeInvalidRequest, Message: fmt.Sprintf("Сообщение должно быть непустым и не длиннее %d символов.", maxMessageLength), status: http.StatusBadRequest})
+		return
+	}
+
+	// Системный промпт сайта или конфигурации; промпт из запроса
+	// учитывается только в пределах политики промптов
+	site := siteFromRequest(r)
+	basePrompt := site.SystemPrompt
+	if basePrompt == "" {
+		basePrompt = state.cfg.SystemPrompt
+		if basePrompt == "" {
+			basePrompt = "Ты полезный AI ассистент. Отвечай кратко и по делу на русском языке."
+		}
+	}
+	vars := newPromptVars(siteName(r, site), requestLanguage(r, req.Language), req.PageURL, req.PageTitle)
+	systemPrompt, err := state.prompts.SystemPrompt(site, basePrompt, req.Preset, req.SystemPrompt, vars)
+	if errors.Is(err, errUnknownPreset) || errors.Is(err, errClientPromptLen) {
+		writeChatError(w, requestID, chatError{Code: codeInvalidRequest, Message: "Некорректный промпт: " + err.Error(), status: http.StatusBadRequest})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка промпта, запрос %s: %v", requestID, err)
+		writeChatError(w, requestID, chatError{Code: codeInternal, Message: "Внутренняя ошибка сервера.", status: http.StatusInternalServerError})
+		return
+	}
+
+	// История хранится на сервере: продолжаем разговор по id или начинаем новый
+	conv, isNew, err := loadConversation(r, sessions, req.ConversationID, site.Key)
+	if err != nil {
+		log.Printf("Ошибка сессии, запрос %s: %v", requestID, err)
+		writeChatError(w, requestID, chatError{Code: codeInternal, Message: "Внутренняя ошибка сервера.", status: http.StatusInternalServerError})
 		return
 	}
 
-	// Получаем системный промпт из запроса или конфигурации
-	systemPrompt := req.SystemPrompt
-	if systemPrompt == "" {
-		cfg, _ := config.Load()
-		systemPrompt = cfg.SystemPrompt
-		if systemPrompt == "" {
-			systemPrompt = "Ты полезный AI ассистент. Отвечай кратко и по делу на русском языке."
+	// Провайдер и модель сайта, если они заданы
+	client := site.chatClient(state.client)
+
+	// Проверяем бюджет: после мягкого лимита переходим на более дешевую
+	// модель, после исчерпания лимита отвечаем без обращения к AI.
+	// Модель BUDGET_FALLBACK_MODEL относится к основному провайдеру,
+	// поэтому для сайтов со своим провайдером не применяется
+	level := tracker.Check(state.budget.Budget)
+	switch level {
+	case usage.LevelExceeded:
+		respondUnavailable(w, r, conv.ID, state.budget.Message)
+		return
+	case usage.LevelSoft:
+		if state.budget.FallbackModel != "" && site.Provider == "" {
+			client = client.WithModel(state.budget.FallbackModel)
+		}
+	}
+
+	history := make([]ai.ChatMessage, len(conv.Messages))
+	for i, msg := range conv.Messages {
+		history[i] = ai.ChatMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
 		}
 	}
 
@@ -875,8 +1126,31 @@ func handleChat(w http.ResponseWriter, r *http.Request, client *ai.Client, aiCon
 		},
 	}
 
+	// Начало длинного разговора заменяется кратким содержанием, которое
+	// хранится вместе с сессией и дополняется только новыми сообщениями.
+	// Содержание составляет модель сайта без второго запроса, со своим
+	// ограничением времени: оно не отнимает время у ответа.
+	if state.contexts.Strategy() == ai.StrategySummarize {
+		summary := ai.Summary{Text: conv.Summary, Count: conv.SummarizedCount}
+		summaryCtx, cancelSummary := context.WithTimeout(r.Context(), time.Duration(state.aiConfig.RequestTimeout)*time.Second)
+		summary, changed, err := state.contexts.Condense(summaryCtx, client, history, summary)
+		cancelSummary()
+		if err != nil {
+			log.Printf("Ошибка сжатия разговора %s: %v", conv.ID, err)
+		}
+		if changed {
+			if err := sessions.SetSummary(context.Background(), conv.ID, summary.Text, summary.Count); err != nil {
+				log.Printf("Ошибка сохранения краткого содержания %s: %v", conv.ID, err)
+			}
+		}
+		if summary.Text != "" {
+			messages = append(messages, ai.SummaryNote(summary))
+			history = history[summary.Count:]
+		}
+	}
+
 	// Добавляем историю
-	messages = append(messages, req.History...)
+	messages = append(messages, history...)
 
 	// Добавляем текущее сообщение
 	messages = append(messages, ai.ChatMessage{
@@ -884,53 +1158,341 @@ func handleChat(w http.ResponseWriter, r *http.Request, client *ai.Client, aiCon
 		Content: req.Message,
 	})
 
-	// Отправляем запрос к AI
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(aiConfig.RequestTimeout)*time.Second)
+	// Второй параллельный запрос пресета или сайта удваивает расход,
+	// поэтому после мягкого лимита бюджета не отправляется
+	if level == usage.LevelOK {
+		client = hedgedClient(client, state.prompts.Hedge(site, req.Preset))
+	}
+
+	// Отправляем запрос к AI. Контекст запроса отменяется, когда браузер
+	// закрывает соединение, - вместе с ним прерывается и запрос к провайдеру
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(state.aiConfig.RequestTimeout)*time.Second)
 	defer cancel()
 
-	response, err := client.Chat(ctx, messages)
+	// Сокращаем историю, чтобы запрос поместился в контекст модели,
+	// которая будет отвечать: у сайта и пресета она может быть своя
+	messages, err = state.contexts.Fit(ctx, client, messages)
+	if err != nil {
+		respondAIError(w, requestID, nil, err)
+		return
+	}
+
+	w.Header().Set("X-Conversation-ID", conv.ID)
+
+	if wantsEventStream(r) {
+		events, err := newEventStream(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		result, err := client.ChatStream(ctx, messages, func(delta string) error {
+			return events.Send("delta", map[string]string{"content": delta})
+		})
+		if err != nil {
+			// Ошибку, в том числе истекший таймаут, некому отправить, только
+			// если браузер уже закрыл соединение
+			if r.Context().Err() == nil {
+				respondAIError(w, requestID, events, err)
+			}
+			return
+		}
+
+		if err := saveTurn(sessions, conv, isNew, req.Message, result); err != nil {
+			log.Printf("Ошибка сохранения разговора %s: %v", conv.ID, err)
+		}
+		events.Send("done", map[string]interface{}{
+			"response":        result.Content,
+			"conversation_id": conv.ID,
+			"usage":           newUsageResponse(result),
+		})
+		return
+	}
+
+	result, err := client.Chat(ctx, messages)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("AI error: %v", err), http.StatusInternalServerError)
+		respondAIError(w, requestID, nil, err)
 		return
 	}
 
+	if err := saveTurn(sessions, conv, isNew, req.Message, result); err != nil {
+		log.Printf("Ошибка сохранения разговора %s: %v", conv.ID, err)
+	}
+
 	// Возвращаем ответ
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"response": response,
+		"response":        result.Content,
+		"conversation_id": conv.ID,
+		"usage":           newUsageResponse(result),
 	})
 }
 
-func handleStatus(w http.ResponseWriter, r *http.Request, client *ai.Client) {
-	w.Header().Set("Content-Type", "application/json")
+// siteName возвращает имя сайта для шаблонов промптов: имя из SITES или
+// адрес страницы, с которой пришел запрос
+func siteName(r *http.Request, site *site) string {
+	if site.Name != "" {
+		return site.Name
+	}
+	if origin, err := url.Parse(r.Header.Get("Origin")); err == nil {
+		return origin.Host
+	}
+	return ""
+}
 
-	status := map[string]interface{}{
-		"configured": client.IsConfigured(),
-		"provider":   client.GetProvider(),
+// requestLanguage возвращает язык пользователя из запроса виджета или
+// первый язык из заголовка Accept-Language
+func requestLanguage(r *http.Request, language string) string {
+	if language != "" {
+		return language
+	}
+	language, _, _ = strings.Cut(r.Header.Get("Accept-Language"), ",")
+	language, _, _ = strings.Cut(language, ";")
+	return strings.TrimSpace(language)
+}
+
+// budgetPolicy лимиты расхода и поведение при их достижении
+type budgetPolicy struct {
+	usage.Budget
+	FallbackModel string // Модель основного провайдера после мягкого лимита
+	Message       string // Ответ пользователю после исчерпания лимита
+}
+
+func newBudgetPolicy(cfg *config.Config) budgetPolicy {
+	return budgetPolicy{
+		Budget: usage.Budget{
+			Daily: usage.Limits{
+				Tokens: cfg.BudgetDailyTokens,
+				Cost:   cfg.BudgetDailyCost,
+			},
+			Monthly: usage.Limits{
+				Tokens: cfg.BudgetMonthlyTokens,
+				Cost:   cfg.BudgetMonthlyCost,
+			},
+			SoftPercent: cfg.BudgetSoftPercent,
+		},
+		FallbackModel: cfg.BudgetFallbackModel,
+		Message:       cfg.BudgetMessage,
 	}
+}
 
-	// Проверяем доступность
-	if client.IsConfigured() {
-		testCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+// respondUnavailable отвечает вежливым сообщением вместо ответа AI. Виджет
+// показывает его как обычный ответ; поле unavailable позволяет отличить его.
+func respondUnavailable(w http.ResponseWriter, r *http.Request, conversationID, message string) {
+	payload := map[string]interface{}{
+		"response":        message,
+		"conversation_id": conversationID,
+		"unavailable":     true,
+	}
 
-		testMessages := []ai.ChatMessage{
-			{Role: "user", Content: "test"},
+	if wantsEventStream(r) {
+		events, err := newEventStream(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		events.Send("done", payload)
+		return
+	}
 
-		_, err := client.Chat(testCtx, testMessages)
-		status["available"] = err == nil
-		if err != nil {
-			status["error"] = err.Error()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payload)
+}
+
+// usageResponse расход на запрос в ответе /api/chat
+type usageResponse struct {
+	ai.Usage
+	Estimated    bool    `json:"estimated,omitempty"`
+	Cost         float64 `json:"cost"`
+	Model        string  `json:"model"`
+	Provider     string  `json:"provider"`
+	FinishReason string  `json:"finish_reason,omitempty"`
+	LatencyMs    int64   `json:"latency_ms"`
+
+	UpstreamRequestID string `json:"upstream_request_id,omitempty"` // Идентификатор запроса у провайдера
+}
+
+func newUsageResponse(result *ai.ChatResult) usageResponse {
+	return usageResponse{
+		Usage:        result.Usage,
+		Estimated:    result.Estimated,
+		Cost:         result.Cost,
+		Model:        result.Model,
+		Provider:     result.Provider,
+		FinishReason: result.FinishReason,
+		LatencyMs:    result.Latency.Milliseconds(),
+
+		UpstreamRequestID: result.RequestID,
+	}
+}
+
+// loadConversation возвращает разговор сайта по id. Если id не указан,
+// разговор истек или начат в другом сайте, начинается новый (isNew): id
+// у него есть сразу, а сохраняется он вместе с первым ответом в saveTurn,
+// чтобы запросы, оставшиеся без ответа, не плодили пустых разговоров.
+func loadConversation(r *http.Request, sessions store.SessionStore, id, siteKey string) (conv *store.Conversation, isNew bool, err error) {
+	if id != "" {
+		conv, err := siteConversation(r.Context(), sessions, id, siteKey)
+		if err == nil {
+			return conv, false, nil
 		}
-	} else {
-		status["available"] = false
+		if !errors.Is(err, store.ErrNotFound) {
+			return nil, false, err
+		}
+	}
+	newID, err := store.NewID()
+	if err != nil {
+		return nil, false, err
+	}
+	return &store.Conversation{ID: newID, Origin: r.Header.Get("Origin"), SiteKey: siteKey}, true, nil
+}
+
+// siteConversation возвращает разговор, начатый в сайте с ключом siteKey.
+// Разговор другого сайта не отличается от несуществующего (ErrNotFound):
+// зная id, его нельзя ни прочитать, ни продолжить под чужим ключом.
+func siteConversation(ctx context.Context, sessions store.SessionStore, id, siteKey string) (*store.Conversation, error) {
+	conv, err := sessions.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if conv.SiteKey != siteKey {
+		return nil, store.ErrNotFound
+	}
+	return conv, nil
+}
+
+// saveTurn сохраняет сообщение пользователя и ответ AI с моделью и расходом
+// токенов, новый разговор сохраняется вместе с ними. Сохранение не зависит
+// от контекста запроса: ответ уже получен и должен попасть в историю.
+func saveTurn(sessions store.SessionStore, conv *store.Conversation, isNew bool, message string, result *ai.ChatResult) error {
+	messages := []store.Message{
+		{Role: "user", Content: message},
+		{
+			Role:             "assistant",
+			Content:          result.Content,
+			Model:            result.Model,
+			PromptTokens:     result.Usage.PromptTokens,
+			CompletionTokens: result.Usage.CompletionTokens,
+		},
+	}
+	if isNew {
+		conv.Messages = messages
+		return sessions.Create(context.Background(), conv)
+	}
+	return sessions.Append(context.Background(), conv.ID, messages...)
+}
+
+// handleConversation возвращает историю разговора, чтобы виджет мог
+// восстановить чат после перезагрузки страницы. Разговор другого сайта
+// отвечает 404, как несуществующий.
+func handleConversation(w http.ResponseWriter, r *http.Request, sessions store.SessionStore) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conv, err := siteConversation(r.Context(), sessions, r.URL.Query().Get("id"), siteFromRequest(r).Key)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Session error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	messages := make([]ai.ChatMessage, len(conv.Messages))
+	for i, msg := range conv.Messages {
+		messages[i] = ai.ChatMessage{Role: msg.Role, Content: msg.Content}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"conversation_id": conv.ID,
+		"messages":        messages,
+	})
+}
+
+// wantsEventStream определяет, запрошен ли потоковый ответ
+func wantsEventStream(r *http.Request) bool {
+	return r.URL.Path == "/api/chat/stream" || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// eventStream передает ответ клиенту через Server-Sent Events.
+// События: delta - очередной фрагмент, done - полный ответ, error - ошибка.
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newEventStream отправляет заголовки потока и возвращает eventStream
+func newEventStream(w http.ResponseWriter) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming not supported")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &eventStream{w: w, flusher: flusher}, nil
+}
+
+// Send записывает одно SSE событие с JSON данными
+func (e *eventStream) Send(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+// handleStatus отдает публичный статус для виджета: настроен ли AI и
+// доступен ли он. Провайдеры и их ошибки видны только в админке.
+func handleStatus(w http.ResponseWriter, r *http.Request, state *appState) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Доступность берется из результатов фоновых проверок: статус
+	// запрашивается виджетом при каждой загрузке страницы
+	status := map[string]interface{}{
+		"configured": state.client.IsConfigured(),
+		"available":  state.health.Ready(),
+	}
+	switch {
+	case !state.client.IsConfigured():
 		status["error"] = "AI не настроен"
+	case !state.health.Ready():
+		status["error"] = "AI временно недоступен"
 	}
 
 	json.NewEncoder(w).Encode(status)
 }
 
+// handleHealthz отвечает, что процесс работает (liveness)
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz отвечает 200, если хотя бы один провайдер AI доступен по
+// последней проверке, иначе 503 (readiness)
+func handleReadyz(w http.ResponseWriter, r *http.Request, state *appState) {
+	ready := state.health.Ready()
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]bool{"ready": ready})
+}
+
 func runConfig() {
 	fmt.Println("🔧 Конфигурация AI Bot")
 	fmt.Println("======================")
@@ -943,30 +1505,71 @@ func runConfig() {
 		return
 	}
 
-	// Проверяем наличие OpenRouter API ключа
-	if cfg.OpenRouterKey == "" {
-		fmt.Println("⚠️  OpenRouter API ключ не найден в .env файле")
-		fmt.Print("Введите OpenRouter API ключ: ")
-		reader := bufio.NewReader(os.Stdin)
-		key, _ := reader.ReadString('\n')
-		cfg.OpenRouterKey = strings.TrimSpace(key)
+	reader := bufio.NewReader(os.Stdin)
 
-		if cfg.OpenRouterKey == "" {
+	// Выбираем провайдера: OpenRouter или локальный Ollama без доступа в интернет
+	providerName := "openrouter"
+	if len(cfg.Providers) > 0 && cfg.Providers[0].Type == "ollama" {
+		providerName = cfg.Providers[0].Name
+	}
+	fmt.Println("Провайдер моделей:")
+	fmt.Println("1. OpenRouter (облако, нужен API ключ)")
+	fmt.Println("2. Ollama (локально, без API ключа)")
+	fmt.Printf("Выберите провайдера [%s]: ", providerName)
+	choice, _ := reader.ReadString('\n')
+	switch strings.TrimSpace(choice) {
+	case "1":
+		providerName = "openrouter"
+	case "2":
+		if providerName != "openrouter" {
+			break
+		}
+		providerName = "ollama"
+	}
+	fmt.Println()
+
+	provider := cfg.EnsureProvider(providerName)
+	local := provider.Type == "ollama"
+	if local {
+		baseURL := provider.URL
+		if baseURL == "" {
+			baseURL = "http://localhost:11434"
+		}
+		fmt.Printf("Адрес сервера Ollama [%s]: ", baseURL)
+		answer, _ := reader.ReadString('\n')
+		if answer = strings.TrimSpace(answer); answer != "" {
+			provider.URL = answer
+		}
+	} else if provider.APIKey == "" {
+		// Проверяем наличие OpenRouter API ключа
+		fmt.Println("⚠️  OpenRouter API ключ не найден")
+		provider.APIKey = strings.TrimSpace(readSecret(reader, "Введите OpenRouter API ключ: "))
+
+		if provider.APIKey == "" {
 			fmt.Println("❌ API ключ не может быть пустым")
 			return
 		}
 	}
 
-	fmt.Println("📡 Загрузка моделей из OpenRouter...")
+	fmt.Printf("📡 Загрузка моделей из %s...\n", provider.Name)
 	fmt.Println()
 
 	// Создаем временный AI клиент для получения моделей
 	aiConfig := &ai.Config{
-		OpenRouterAPIKey: cfg.OpenRouterKey,
-		OpenRouterURL:    "https://openrouter.ai/api/v1",
-		RequestTimeout:   30,
+		Providers: []ai.ProviderConfig{{
+			Name:    provider.Name,
+			Type:    provider.Type,
+			BaseURL: provider.URL,
+			APIKey:  provider.APIKey,
+			Headers: provider.Headers,
+		}},
+		RequestTimeout: 30,
+	}
+	client, err := ai.NewClient(aiConfig)
+	if err != nil {
+		fmt.Printf("❌ Ошибка конфигурации провайдера: %v\n", err)
+		return
 	}
-	client := ai.NewClient(aiConfig)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -979,56 +1582,71 @@ func runConfig() {
 
 	if len(models) == 0 {
 		fmt.Println("❌ Модели не найдены")
+		if local {
+			fmt.Println("   Загрузите модель командой: ollama pull llama3.1")
+		}
 		return
 	}
 
 	// Запускаем интерактивный выбор модели
-	selectedModel := selectModelInteractive(models)
+	selectedModel := selectModelInteractive(models, local)
 	if selectedModel == "" {
 		fmt.Println("❌ Модель не выбрана")
 		return
 	}
 
-	// Сохраняем выбранную модель
-	cfg.OpenRouterModel = selectedModel
+	// Сохраняем выбранную модель, выбранный провайдер становится основным
+	provider.Model = selectedModel
+	moveProviderFirst(cfg, provider.Name)
+	provider = cfg.Provider(providerName)
 
 	// Настройка системного промпта
 	fmt.Println()
 	fmt.Println("🎯 Настройка системного промпта")
 	fmt.Println("================================")
-	fmt.Printf("Текущий промпт: %s\n", cfg.SystemPrompt)
+	if cfg.PromptPreset != "" {
+		fmt.Printf("Текущий пресет: %s\n", cfg.PromptPreset)
+	} else {
+		fmt.Printf("Текущий промпт: %s\n", cfg.SystemPrompt)
+	}
 	fmt.Println()
 	fmt.Print("Хотите изменить системный промпт? (y/n): ")
-	reader := bufio.NewReader(os.Stdin)
 	answer, _ := reader.ReadString('\n')
 	answer = strings.TrimSpace(strings.ToLower(answer))
 
 	if answer == "y" || answer == "yes" || answer == "да" {
+		presets, err := cfg.Presets()
+		if err != nil {
+			fmt.Printf("❌ Ошибка пресетов промптов: %v\n", err)
+		}
+
 		fmt.Println()
-		fmt.Println("Примеры системных промптов:")
-		fmt.Println("1. Дружелюбный помощник:")
-		fmt.Println("   Ты дружелюбный AI помощник. Общайся тепло и неформально, используй эмодзи.")
-		fmt.Println()
-		fmt.Println("2. Профессиональный консультант:")
-		fmt.Println("   Ты профессиональный консультант. Давай четкие, структурированные ответы с примерами.")
-		fmt.Println()
-		fmt.Println("3. Программист-наставник:")
-		fmt.Println("   Ты опытный программист. Помогай с кодом, объясняй концепции, предлагай лучшие практики.")
-		fmt.Println()
-		fmt.Println("4. Креативный помощник:")
-		fmt.Println("   Ты креативный помощник. Генерируй идеи, помогай с творческими задачами, вдохновляй.")
-		fmt.Println()
-		fmt.Print("Введите новый системный промпт (или нажмите Enter для пропуска): ")
-		
+		fmt.Printf("Пресеты промптов (%s):\n", cfg.PromptsFile)
+		for i, preset := range presets {
+			fmt.Printf("%d. %s (%s):\n", i+1, preset.Description, preset.ID)
+			fmt.Printf("   %s\n", strings.TrimSpace(preset.Template))
+			fmt.Println()
+		}
+		fmt.Print("Введите номер пресета, новый системный промпт или нажмите Enter для пропуска: ")
+
 		newPrompt, _ := reader.ReadString('\n')
 		newPrompt = strings.TrimSpace(newPrompt)
-		
-		if newPrompt != "" {
+
+		if n, err := strconv.Atoi(newPrompt); err == nil && n >= 1 && n <= len(presets) {
+			cfg.PromptPreset = presets[n-1].ID
+			fmt.Printf("✅ Выбран пресет %s\n", cfg.PromptPreset)
+		} else if newPrompt != "" {
 			cfg.SystemPrompt = newPrompt
+			cfg.PromptPreset = ""
 			fmt.Printf("✅ Системный промпт обновлен\n")
 		}
 	}
 
+	// Ключи API сохраняются в выбранном хранилище
+	if !local && !chooseSecretsStorage(reader, cfg) {
+		return
+	}
+
 	// Сохраняем конфигурацию
 	if err := config.Save(cfg); err != nil {
 		fmt.Printf("❌ Ошибка сохранения конфигурации: %v\n", err)
@@ -1037,13 +1655,173 @@ func runConfig() {
 
 	fmt.Println()
 	fmt.Printf("✅ Конфигурация успешно сохранена в .env файл\n")
+	fmt.Printf("   Провайдер: %s\n", provider.Name)
 	fmt.Printf("   Модель: %s\n", selectedModel)
-	fmt.Printf("   Промпт: %s\n", cfg.SystemPrompt)
+	if cfg.PromptPreset != "" {
+		fmt.Printf("   Пресет: %s\n", cfg.PromptPreset)
+	} else {
+		fmt.Printf("   Промпт: %s\n", cfg.SystemPrompt)
+	}
 	fmt.Println()
 	fmt.Println("Теперь вы можете запустить бота:")
 	fmt.Println("  ./ai-bot.exe")
 }
 
+// chooseSecretsStorage спрашивает, где хранить ключи API: в .env, в
+// зашифрованном файле секретов или вне конфигурации (переменные окружения,
+// файлы <ИМЯ>_FILE). Возвращает false, если сохранить ключи не удалось.
+func chooseSecretsStorage(reader *bufio.Reader, cfg *config.Config) bool {
+	current := "1"
+	if cfg.SecretsFile != "" {
+		current = "2"
+	}
+	fmt.Println()
+	fmt.Println("🔐 Хранение ключей API")
+	fmt.Println("1. Файл .env (открытым текстом)")
+	fmt.Println("2. Зашифрованный файл секретов")
+	fmt.Println("3. Не сохранять (переменные окружения или файлы *_API_KEY_FILE)")
+	fmt.Printf("Выберите хранилище [%s]: ", current)
+	choice, _ := reader.ReadString('\n')
+	if choice = strings.TrimSpace(choice); choice == "" {
+		choice = current
+	}
+
+	switch choice {
+	case "2":
+		path := cfg.SecretsFile
+		if path == "" {
+			path = "secrets.enc"
+		}
+		fmt.Printf("Файл секретов [%s]: ", path)
+		if answer, _ := reader.ReadString('\n'); strings.TrimSpace(answer) != "" {
+			path = strings.TrimSpace(answer)
+		}
+
+		// Пароль не сохраняется, без пароля создается файл ключа
+		unlock := config.SecretsUnlock{KeyFile: cfg.SecretsKeyFile}
+		if unlock.KeyFile == "" {
+			unlock.Passphrase = readSecret(reader, "Пароль файла секретов (Enter - создать файл ключа secrets.key): ")
+			if unlock.Passphrase == "" {
+				unlock.KeyFile = "secrets.key"
+				if _, err := os.Stat(unlock.KeyFile); os.IsNotExist(err) {
+					if err := config.NewSecretsKeyFile(unlock.KeyFile); err != nil {
+						fmt.Printf("❌ Ошибка создания файла ключа: %v\n", err)
+						return false
+					}
+					fmt.Printf("✅ Создан файл ключа %s, храните его отдельно от файла секретов\n", unlock.KeyFile)
+				}
+			}
+		}
+
+		cfg.SecretsFile = path
+		cfg.SecretsKeyFile = unlock.KeyFile
+		if err := config.SaveSecrets(cfg, unlock); err != nil {
+			fmt.Printf("❌ Ошибка сохранения файла секретов: %v\n", err)
+			return false
+		}
+		fmt.Printf("✅ Ключи сохранены в %s\n", path)
+		if unlock.Passphrase != "" {
+			fmt.Println("   При запуске задайте пароль в SECRETS_PASSPHRASE или SECRETS_PASSPHRASE_FILE")
+		}
+	case "3":
+		for i := range cfg.Providers {
+			if cfg.Providers[i].APIKey == "" {
+				continue
+			}
+			fmt.Printf("   При запуске задайте %s_API_KEY или %s_API_KEY_FILE\n",
+				config.EnvPrefix(cfg.Providers[i].Name), config.EnvPrefix(cfg.Providers[i].Name))
+			cfg.Providers[i].APIKey = ""
+		}
+	default:
+		cfg.SecretsFile = ""
+		cfg.SecretsKeyFile = ""
+	}
+	return true
+}
+
+// runPrintConfig печатает действующую конфигурацию в формате файла
+// конфигурации. Ключи API, токен и хэш пароля сокращаются. Конфигурация
+// печатается и с ошибками, их показывает --check-config.
+func runPrintConfig() {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Ошибка загрузки конфигурации: %v\n", err)
+		os.Exit(1)
+	}
+	applyFlags(cfg)
+
+	mask := func(secret *string) {
+		if *secret != "" {
+			*secret = maskKey(*secret)
+		}
+	}
+	for i := range cfg.Providers {
+		mask(&cfg.Providers[i].APIKey)
+	}
+	mask(&cfg.AdminToken)
+	mask(&cfg.AdminPasswordHash)
+
+	fmt.Println("# Действующая конфигурация: аргументы > переменные окружения > .env > --config-file > значения по умолчанию")
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(config.NewFileConfig(cfg)); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Ошибка: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runCheckConfig проверяет конфигурацию так же, как при запуске сервера:
+// значения, провайдеры, пресеты промптов, сайты и доступ к админке
+func runCheckConfig() {
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fail(err)
+	}
+	tracker, err := usage.NewTracker(cfg.UsageFile)
+	if err != nil {
+		fail(err)
+	}
+	if _, err := newAppState(cfg, tracker, nil); err != nil {
+		fail(err)
+	}
+	if cfg.AdminPasswordHash != "" && !validPasswordHash(cfg.AdminPasswordHash) {
+		fail(fmt.Errorf("ADMIN_PASSWORD_HASH has unknown format, generate it with --hash-password"))
+	}
+	fmt.Println("✅ Конфигурация корректна")
+}
+
+// runHashPassword читает пароль администратора и печатает его хэш для .env
+func runHashPassword() {
+	password := readSecret(bufio.NewReader(os.Stdin), "Пароль администратора: ")
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "❌ Пароль не может быть пустым")
+		os.Exit(1)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Ошибка: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("ADMIN_PASSWORD_HASH=%s\n", hash)
+}
+
+// moveProviderFirst переносит провайдера в начало цепочки fallback
+func moveProviderFirst(cfg *config.Config, name string) {
+	for i, provider := range cfg.Providers {
+		if provider.Name == name {
+			copy(cfg.Providers[1:i+1], cfg.Providers[:i])
+			cfg.Providers[0] = provider
+			return
+		}
+	}
+}
+
 // TUI модель для выбора AI модели
 type modelSelectorModel struct {
 	categories      []modelCategory
@@ -1254,8 +2032,9 @@ func (m modelSelectorModel) View() string {
 	return s.String()
 }
 
-// Интерактивный выбор модели с TUI интерфейсом
-func selectModelInteractive(models []ai.ModelInfo) string {
+// Интерактивный выбор модели с TUI интерфейсом.
+// Для локального провайдера все модели показываются одной категорией.
+func selectModelInteractive(models []ai.ModelInfo, local bool) string {
 	// Категоризируем модели
 	freeModels := []ai.ModelInfo{}
 	popularModels := []ai.ModelInfo{}
@@ -1312,6 +2091,11 @@ func selectModelInteractive(models []ai.ModelInfo) string {
 		currentCategory: 0,
 		currentModel:    0,
 	}
+	if local {
+		m.categories = []modelCategory{
+			{"Локальные модели", allModels, "💻"},
+		}
+	}
 
 	// Запускаем TUI
 	p := tea.NewProgram(m, tea.WithAltScreen())
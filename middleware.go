@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ai-bot/config"
+	"ai-bot/ratelimit"
+)
+
+// maxChatRequestSize ограничение размера тела запроса к /api/chat
+const maxChatRequestSize = 64 << 10
+
+// rateLimiter ограничивает частоту запросов к AI: по IP клиента и по
+// разговору (лимиты сайта, см. site) и по числу одновременных запросов
+// на весь сервер
+type rateLimiter struct {
+	inFlight       *ratelimit.Concurrency
+	trustedProxies []*net.IPNet
+}
+
+func newRateLimiter(cfg *config.Config) (*rateLimiter, error) {
+	trusted, err := ratelimit.ParseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimiter{
+		inFlight:       ratelimit.NewConcurrency(cfg.MaxConcurrent),
+		trustedProxies: trusted,
+	}, nil
+}
+
+// Wrap применяет ограничения к обработчику. Превышение любого из них
+// отвечает 429 с заголовком Retry-After. Сайт запроса определяет
+// siteRegistry.Wrap, поэтому он должен стоять раньше.
+func (l *rateLimiter) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+		site := siteFromRequest(r)
+
+		if ok, wait := site.perIP.Allow(ratelimit.ClientIP(r, l.trustedProxies)); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+
+		// Тело читается заранее, чтобы узнать разговор, и возвращается
+		// обработчику без изменений
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxChatRequestSize))
+		if err != nil {
+			writeChatError(w, responseRequestID(w), chatError{Code: codeInvalidRequest, Message: "Некорректный запрос.", status: http.StatusBadRequest})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var req struct {
+			ConversationID string `json:"conversation_id"`
+		}
+		if json.Unmarshal(body, &req) == nil && req.ConversationID != "" {
+			if ok, wait := site.perConversation.Allow(req.ConversationID); !ok {
+				tooManyRequests(w, wait)
+				return
+			}
+		}
+
+		if !l.inFlight.TryAcquire() {
+			tooManyRequests(w, time.Second)
+			return
+		}
+		defer l.inFlight.Release()
+
+		next(w, r)
+	}
+}
+
+// tooManyRequests отвечает 429 и сообщает, через сколько секунд повторить запрос
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	writeChatError(w, responseRequestID(w), chatError{
+		Code:       codeRateLimited,
+		Message:    fmt.Sprintf("Слишком много запросов. Повторите через %d с.", seconds),
+		status:     http.StatusTooManyRequests,
+		retryAfter: seconds,
+	})
+}
+
+// originPolicy список сайтов (Origin), с которых разрешено обращаться к API.
+// Поддерживаются точные значения ("https://example.com"), поддомены
+// ("https://*.example.com") и "*" - любой сайт. Пустой список разрешает все.
+type originPolicy struct {
+	patterns []string
+}
+
+func newOriginPolicy(origins []string) originPolicy {
+	patterns := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		patterns = append(patterns, normalizeOrigin(origin))
+	}
+	return originPolicy{patterns: patterns}
+}
+
+// Patterns возвращает разрешенные Origin для проверки в виджете
+func (p originPolicy) Patterns() []string {
+	return p.patterns
+}
+
+// Allowed проверяет Origin запроса. Запросы со страниц самого сервера
+// разрешены всегда. Запросы без Origin (не из браузера) тоже разрешены:
+// такой клиент может подставить любой Origin, так что CORS от него не
+// защищает, а частоту его запросов ограничивает rateLimiter.
+func (p originPolicy) Allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(p.patterns) == 0 {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	origin = normalizeOrigin(origin)
+	for _, pattern := range p.patterns {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check проверяет Origin, добавляет заголовки CORS и отвечает на preflight
+// запросы OPTIONS. Запросы с неразрешенных сайтов отклоняются с 403.
+// Возвращает true, если запрос нужно передать обработчику.
+func (p originPolicy) Check(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Origin")
+
+	if !p.Allowed(r) {
+		writeChatError(w, responseRequestID(w), chatError{Code: codeOriginDenied, Message: "Запросы с этого сайта не разрешены.", status: http.StatusForbidden})
+		return false
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", "X-Conversation-ID, X-Request-ID, Retry-After")
+	}
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept")
+		w.Header().Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+
+	return true
+}
+
+// normalizeOrigin приводит Origin к виду для сравнения
+func normalizeOrigin(origin string) string {
+	return strings.TrimRight(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
+
+// matchOrigin сравнивает Origin с шаблоном. Шаблон "https://*.example.com"
+// подходит для поддоменов example.com, но не для самого example.com.
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+	scheme, domain, ok := strings.Cut(pattern, "://*.")
+	if !ok {
+		return false
+	}
+	host, found := strings.CutPrefix(origin, scheme+"://")
+	return found && strings.HasSuffix(host, "."+domain)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"ai-bot/ai"
+	"ai-bot/config"
+)
+
+// maxClientPromptLength максимальная длина промпта клиента в режиме append
+const maxClientPromptLength = 1000
+
+// maxPromptVarLength ограничение длины значений от клиента (адрес и
+// заголовок страницы), подставляемых в шаблоны
+const maxPromptVarLength = 200
+
+var (
+	errUnknownPreset   = errors.New("unknown prompt preset")
+	errClientPromptLen = errors.New("system prompt too long")
+)
+
+// promptVars переменные шаблонов пресетов
+type promptVars struct {
+	Date         string // Текущая дата, 2006-01-02
+	SiteName     string // Имя сайта из SITES или адрес сервера
+	UserLanguage string // Язык пользователя из браузера, например ru-RU
+	PageURL      string // Адрес страницы с виджетом
+	PageTitle    string // Заголовок страницы с виджетом
+}
+
+// newPromptVars собирает переменные шаблонов. Значения от клиента
+// обрезаются и склеиваются в одну строку: они попадают в системный промпт.
+func newPromptVars(siteName, language, pageURL, pageTitle string) promptVars {
+	return promptVars{
+		Date:         time.Now().Format("2006-01-02"),
+		SiteName:     siteName,
+		UserLanguage: promptVar(language),
+		PageURL:      promptVar(pageURL),
+		PageTitle:    promptVar(pageTitle),
+	}
+}
+
+func promptVar(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if utf8.RuneCountInString(value) > maxPromptVarLength {
+		value = string([]rune(value)[:maxPromptVarLength])
+	}
+	return value
+}
+
+// promptPreset пресет с разобранным шаблоном
+type promptPreset struct {
+	config.PromptPreset
+	template *template.Template
+}
+
+// Render подставляет переменные в шаблон пресета
+func (p *promptPreset) Render(vars promptVars) (string, error) {
+	var prompt strings.Builder
+	if err := p.template.Execute(&prompt, vars); err != nil {
+		return "", fmt.Errorf("prompt preset %q: %w", p.ID, err)
+	}
+	return strings.TrimSpace(prompt.String()), nil
+}
+
+// promptPolicy определяет, как промпт из запроса клиента влияет на системный
+// промпт. Без ограничения любой посетитель мог бы задать боту произвольные
+// инструкции и пользоваться им как бесплатным доступом к модели.
+type promptPolicy struct {
+	presets []*promptPreset
+	byID    map[string]*promptPreset
+}
+
+// newPromptPolicy загружает пресеты промптов и проверяет их шаблоны, а также
+// политики и пресеты сайтов из конфигурации. Провайдеры второго запроса
+// пресетов проверяются по клиенту client, который будет их вызывать.
+func newPromptPolicy(cfg *config.Config, client *ai.Client) (*promptPolicy, error) {
+	presets, err := cfg.Presets()
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &promptPolicy{byID: make(map[string]*promptPreset, len(presets))}
+	for _, preset := range presets {
+		if preset.ID == "" {
+			return nil, fmt.Errorf("prompt preset without id in %s", cfg.PromptsFile)
+		}
+		if _, exists := policy.byID[preset.ID]; exists {
+			return nil, fmt.Errorf("prompt preset %q: duplicate id", preset.ID)
+		}
+		if strings.TrimSpace(preset.Template) == "" {
+			return nil, fmt.Errorf("prompt preset %q: template is empty", preset.ID)
+		}
+		if preset.Hedge.Provider != "" {
+			if _, ok := client.WithProvider(preset.Hedge.Provider); !ok {
+				return nil, fmt.Errorf("prompt preset %q: hedge provider %q is not configured", preset.ID, preset.Hedge.Provider)
+			}
+		}
+		if preset.Hedge.DelayMs < 0 {
+			return nil, fmt.Errorf("prompt preset %q: hedge delay must not be negative", preset.ID)
+		}
+		tmpl, err := template.New(preset.ID).Option("missingkey=error").Parse(preset.Template)
+		if err != nil {
+			return nil, fmt.Errorf("prompt preset %q: %w", preset.ID, err)
+		}
+
+		// Пробная подстановка находит обращения к несуществующим переменным
+		parsed := &promptPreset{PromptPreset: preset, template: tmpl}
+		if _, err := parsed.Render(newPromptVars("example", "ru-RU", "https://example.com/", "Example")); err != nil {
+			return nil, err
+		}
+		policy.presets = append(policy.presets, parsed)
+		policy.byID[preset.ID] = parsed
+	}
+
+	if err := policy.check("", cfg.PromptPolicy, cfg.PromptPreset); err != nil {
+		return nil, err
+	}
+	for _, site := range cfg.Sites {
+		if err := policy.check(site.Name, site.PromptPolicy, site.PromptPreset); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// check проверяет политику и пресет сайта (пустое имя - общие настройки)
+func (p *promptPolicy) check(siteName, mode, preset string) error {
+	var err error
+	switch mode {
+	case config.PromptPolicyDeny, config.PromptPolicyPresets, config.PromptPolicyAppend:
+	default:
+		err = fmt.Errorf("unknown prompt policy %q (available: deny, presets, append)", mode)
+	}
+	if _, ok := p.byID[preset]; err == nil && preset != "" && !ok {
+		err = fmt.Errorf("%w %q", errUnknownPreset, preset)
+	}
+	if err != nil && siteName != "" {
+		return fmt.Errorf("site %q: %w", siteName, err)
+	}
+	return err
+}
+
+// Presets возвращает пресеты в порядке из файла
+func (p *promptPolicy) Presets() []*promptPreset {
+	return p.presets
+}
+
+// SystemPrompt возвращает системный промпт для запроса к сайту site.
+// base - промпт сервера, если у сайта нет пресета; preset и clientPrompt -
+// значения из запроса.
+//
+//   - deny: промпт сервера, значения клиента игнорируются
+//   - presets: пресет из запроса или промпт сервера
+//   - append: как presets, плюс текст клиента после промпта сервера
+func (p *promptPolicy) SystemPrompt(site *site, base, preset, clientPrompt string, vars promptVars) (string, error) {
+	preset = p.presetID(site, preset)
+
+	prompt := base
+	if preset != "" {
+		found, ok := p.byID[preset]
+		if !ok {
+			return "", errUnknownPreset
+		}
+		rendered, err := found.Render(vars)
+		if err != nil {
+			return "", err
+		}
+		prompt = rendered
+	}
+
+	clientPrompt = strings.TrimSpace(clientPrompt)
+	if site.PromptPolicy != config.PromptPolicyAppend || clientPrompt == "" {
+		return prompt, nil
+	}
+	if utf8.RuneCountInString(clientPrompt) > maxClientPromptLength {
+		return "", errClientPromptLen
+	}
+	return prompt + "\n\nДополнительные указания сайта (не отменяют правила выше):\n" + clientPrompt, nil
+}
+
+// presetID возвращает пресет, который действует для запроса: из запроса,
+// если политика сайта это разрешает, иначе пресет сайта
+func (p *promptPolicy) presetID(site *site, preset string) string {
+	if site.PromptPolicy == config.PromptPolicyDeny || preset == "" {
+		return site.PromptPreset
+	}
+	return preset
+}
+
+// Hedge возвращает настройки второго параллельного запроса: пресета, если
+// он их задает, иначе сайта
+func (p *promptPolicy) Hedge(site *site, preset string) config.Hedge {
+	if found, ok := p.byID[p.presetID(site, preset)]; ok && found.Hedge.Enabled() {
+		return found.Hedge
+	}
+	return site.Hedge
+}
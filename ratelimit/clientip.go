@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRs разбирает список сетей доверенных прокси. Допускаются как
+// сети ("10.0.0.0/8"), так и отдельные адреса ("127.0.0.1").
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network %q: %w", value, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ClientIP возвращает адрес клиента. X-Forwarded-For учитывается, только
+// если запрос пришел от доверенного прокси: цепочка просматривается справа
+// налево, и первый адрес не из доверенных сетей считается адресом клиента.
+// Так клиент не может подменить свой адрес, дописав заголовок сам.
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !isTrusted(remote, trusted) {
+		return remote
+	}
+
+	var chain []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(header, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				chain = append(chain, addr)
+			}
+		}
+	}
+
+	client := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		if net.ParseIP(chain[i]) == nil {
+			// Испорченная цепочка: дальше доверять ей нельзя
+			break
+		}
+		client = chain[i]
+		if !isTrusted(client, trusted) {
+			break
+		}
+	}
+	return client
+}
+
+// isTrusted проверяет, входит ли адрес в доверенные сети
+func isTrusted(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{"10.0.0.0/8", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct client", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted peer ignores header", "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"trusted proxy", "127.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"client prepends a fake address", "127.0.0.1:1234", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "127.0.0.1:1234", []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"}, "198.51.100.1"},
+		{"only trusted addresses", "127.0.0.1:1234", []string{"10.0.0.2"}, "10.0.0.2"},
+		{"broken chain", "127.0.0.1:1234", []string{"198.51.100.1, unknown"}, "127.0.0.1"},
+		{"trusted proxy without header", "10.1.2.3:80", nil, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tt.remote, Header: http.Header{}}
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := ClientIP(r, trusted); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	if _, err := ParseCIDRs([]string{"10.0.0.0/8", " ::1 ", ""}); err != nil {
+		t.Errorf("ParseCIDRs() = %v", err)
+	}
+	for _, value := range []string{"10.0.0.0/33", "proxy.local"} {
+		if _, err := ParseCIDRs([]string{value}); err == nil {
+			t.Errorf("ParseCIDRs(%q) succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval как часто удалять неактивные ключи
+const sweepInterval = time.Minute
+
+// Limiter ограничивает частоту запросов по ключу (IP, разговор) алгоритмом
+// token bucket: у каждого ключа есть запас из burst запросов, который
+// пополняется со скоростью perMinute запросов в минуту.
+// Нулевой *Limiter ничего не ограничивает.
+type Limiter struct {
+	mu        sync.Mutex
+	rate      float64 // Пополнение, запросов в секунду
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time // Часы, в тестах подменяются
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter создает ограничитель. perMinute <= 0 отключает ограничение
+// (возвращается nil), burst <= 0 - запас равен одному запросу.
+func NewLimiter(perMinute, burst int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:      float64(perMinute) / 60,
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow расходует запрос для ключа. Если запас исчерпан, возвращает false
+// и время, через которое появится следующий запрос.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep удаляет ключи, запас которых полностью восстановился: они
+// ничем не отличаются от новых
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Concurrency ограничивает число одновременно выполняемых запросов.
+// Нулевой *Concurrency ничего не ограничивает.
+type Concurrency struct {
+	slots chan struct{}
+}
+
+// NewConcurrency создает ограничение на limit одновременных запросов.
+// limit <= 0 отключает ограничение (возвращается nil).
+func NewConcurrency(limit int) *Concurrency {
+	if limit <= 0 {
+		return nil
+	}
+	return &Concurrency{slots: make(chan struct{}, limit)}
+}
+
+// TryAcquire занимает слот без ожидания. Возвращает false, если свободных нет.
+func (c *Concurrency) TryAcquire() bool {
+	if c == nil {
+		return true
+	}
+	select {
+	case c.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release освобождает слот, занятый TryAcquire
+func (c *Concurrency) Release() {
+	if c == nil {
+		return
+	}
+	<-c.slots
+}
+
+// InFlight возвращает число занятых слотов
+func (c *Concurrency) InFlight() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.slots)
+}
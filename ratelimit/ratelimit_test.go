@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterRefillAndBurst(t *testing.T) {
+	type step struct {
+		after time.Duration // Пауза перед запросом
+		key   string
+		ok    bool
+		wait  time.Duration
+	}
+	tests := []struct {
+		name      string
+		perMinute int
+		burst     int
+		steps     []step
+	}{
+		{
+			name:      "burst then refill",
+			perMinute: 60, burst: 3,
+			steps: []step{
+				{0, "a", true, 0},
+				{0, "a", true, 0},
+				{0, "a", true, 0},
+				{0, "a", false, time.Second},
+				{500 * time.Millisecond, "a", false, 500 * time.Millisecond},
+				{500 * time.Millisecond, "a", true, 0},
+				{0, "a", false, time.Second},
+			},
+		},
+		{
+			name:      "refill is capped by burst",
+			perMinute: 60, burst: 2,
+			steps: []step{
+				{0, "a", true, 0},
+				{0, "a", true, 0},
+				{time.Hour, "a", true, 0},
+				{0, "a", true, 0},
+				{0, "a", false, time.Second},
+			},
+		},
+		{
+			name:      "zero burst allows one request",
+			perMinute: 6, burst: 0,
+			steps: []step{
+				{0, "a", true, 0},
+				{0, "a", false, 10 * time.Second},
+				{10 * time.Second, "a", true, 0},
+			},
+		},
+		{
+			name:      "keys are limited separately",
+			perMinute: 60, burst: 1,
+			steps: []step{
+				{0, "conversation-1", true, 0},
+				{0, "conversation-1", false, time.Second},
+				{0, "conversation-2", true, 0},
+				{0, "conversation-2", false, time.Second},
+				{time.Second, "conversation-1", true, 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.perMinute, tt.burst)
+			now := time.Now()
+			l.now = func() time.Time { return now }
+			for i, s := range tt.steps {
+				now = now.Add(s.after)
+				ok, wait := l.Allow(s.key)
+				if ok != s.ok || wait != s.wait {
+					t.Errorf("step %d: Allow(%q) = %v, %v; want %v, %v", i, s.key, ok, wait, s.ok, s.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := NewLimiter(0, 5)
+	for range 100 {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatal("disabled limiter rejected a request")
+		}
+	}
+}
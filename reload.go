@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"ai-bot/ai"
+	"ai-bot/config"
+	"ai-bot/usage"
+)
+
+// configCheckInterval период проверки файлов конфигурации на изменения
+const configCheckInterval = 2 * time.Second
+
+// errNoProviders конфигурация без единого настроенного провайдера AI
+var errNoProviders = errors.New("no AI provider is configured")
+
+// restartKeys настройки, которые применяются только при запуске сервера
+var restartKeys = []string{
+	"HOST", "PORT", "SESSION_STORE", "SQLITE_PATH", "SESSION_TTL", "USAGE_FILE",
+	"ADMIN_PASSWORD_HASH", "ADMIN_TOKEN",
+}
+
+// appState настройки и построенные из них объекты, с которыми работают
+// обработчики. Перезагрузка конфигурации создает новое состояние целиком:
+// запрос берет состояние один раз и до конца работает с ним, поэтому
+// начатые запросы завершаются со старыми настройками.
+type appState struct {
+	cfg      *config.Config
+	aiConfig *ai.Config
+	client   *ai.Client
+	contexts *ai.ContextManager
+	budget   budgetPolicy
+	prompts  *promptPolicy
+	limiter  *rateLimiter
+	sites    *siteRegistry
+	health   *ai.HealthProber
+}
+
+// newAppState проверяет конфигурацию и строит состояние. Счетчики
+// ограничений запросов и результаты проверок провайдеров переходят из
+// previous. Проверки провайдеров запускает вызывающий (health.Start).
+func newAppState(cfg *config.Config, tracker *usage.Tracker, previous *appState) (*appState, error) {
+	aiConfig := newAIConfig(cfg)
+	client, err := ai.NewClient(aiConfig)
+	if err != nil {
+		return nil, fmt.Errorf("AI providers: %w", err)
+	}
+	if !client.IsConfigured() {
+		return nil, errNoProviders
+	}
+	client.SetUsageHook(tracker.Record)
+
+	// Промпты из запросов клиентов ограничены политикой и пресетами сервера
+	prompts, err := newPromptPolicy(cfg, client)
+	if err != nil {
+		return nil, fmt.Errorf("prompts: %w", err)
+	}
+
+	// Ограничение частоты запросов: обращения к AI платные
+	limiter, err := newRateLimiter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("rate limit: %w", err)
+	}
+
+	// Сайты по ключу: у каждого свои промпт, модель, лимиты и разрешенные
+	// адреса (CORS). Запросы без ключа используют общие настройки
+	sites, err := newSiteRegistry(cfg, client)
+	if err != nil {
+		return nil, fmt.Errorf("sites: %w", err)
+	}
+
+	// Доступность провайдеров проверяется в фоне, а не на каждый запрос статуса
+	health := ai.NewHealthProber(client, time.Duration(cfg.HealthCheckInterval)*time.Second)
+
+	if previous != nil {
+		health.Inherit(previous.health)
+		if previous.cfg.MaxConcurrent == cfg.MaxConcurrent {
+			limiter.inFlight = previous.limiter.inFlight
+		}
+		sites.inherit(previous.sites)
+	}
+
+	return &appState{
+		cfg:      cfg,
+		aiConfig: aiConfig,
+		client:   client,
+		// Управление контекстом: длинная история сокращается под размер контекста модели
+		contexts: ai.NewContextManager(ai.ContextConfig{
+			Strategy:             ai.ContextStrategy(cfg.ContextStrategy),
+			WindowMessages:       cfg.ContextWindow,
+			DefaultContextLength: cfg.DefaultContextLength,
+			SummaryThreshold:     cfg.SummaryThreshold,
+			SummaryKeepRecent:    cfg.SummaryKeepRecent,
+		}),
+		budget:  newBudgetPolicy(cfg),
+		prompts: prompts,
+		limiter: limiter,
+		sites:   sites,
+		health:  health,
+	}, nil
+}
+
+// configManager хранит текущее состояние и перезагружает конфигурацию при
+// изменении файла конфигурации, .env, файла пресетов или файла секретов,
+// а также по сигналу SIGHUP
+type configManager struct {
+	state   atomic.Pointer[appState]
+	tracker *usage.Tracker
+	load    func() (*config.Config, error) // Чтение конфигурации с аргументами командной строки
+
+	mu     sync.Mutex // Перезагрузки выполняются по одной
+	stamps map[string]fileStamp
+}
+
+func newConfigManager(state *appState, tracker *usage.Tracker, load func() (*config.Config, error)) *configManager {
+	m := &configManager{tracker: tracker, load: load}
+	m.state.Store(state)
+	m.stamps = fileStamps(state.cfg)
+	return m
+}
+
+// Current возвращает действующее состояние
+func (m *configManager) Current() *appState {
+	return m.state.Load()
+}
+
+// Wrap передает обработчику состояние на момент начала запроса
+func (m *configManager) Wrap(next func(w http.ResponseWriter, r *http.Request, state *appState)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r, m.Current())
+	}
+}
+
+// Reload читает конфигурацию заново и применяет ее, если она корректна.
+// При ошибке продолжает работать прежняя конфигурация.
+func (m *configManager) Reload(reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Файлы запоминаются до чтения: изменение во время перезагрузки будет
+	// замечено при следующей проверке, а ошибочный файл не читается повторно
+	previous := m.Current()
+	m.stamps = fileStamps(previous.cfg)
+
+	cfg, err := m.load()
+	if err == nil {
+		if cfg.PromptsFile != previous.cfg.PromptsFile || cfg.SecretsFile != previous.cfg.SecretsFile {
+			m.stamps = fileStamps(cfg)
+		}
+		var state *appState
+		state, err = newAppState(cfg, m.tracker, previous)
+		if err == nil {
+			m.state.Store(state)
+			state.health.Start()
+			previous.health.Stop()
+			logConfigChanges(reason, previous.cfg, cfg)
+			return nil
+		}
+	}
+	log.Printf("Конфигурация не применена (%s): %v", reason, err)
+	return err
+}
+
+// Watch перезагружает конфигурацию по SIGHUP и при изменении файлов
+func (m *configManager) Watch() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	ticker := time.NewTicker(configCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signals:
+			m.Reload("SIGHUP")
+		case <-ticker.C:
+			if changed := m.changedFile(); changed != "" {
+				m.Reload("изменен " + changed)
+			}
+		}
+	}
+}
+
+// fileStamp время изменения и размер файла; нулевой - файла нет
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// fileStamps возвращает состояние файлов, из которых читается конфигурация
+func fileStamps(cfg *config.Config) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, path := range []string{config.File, config.EnvFile, cfg.PromptsFile, cfg.SecretsFile} {
+		if path == "" {
+			continue
+		}
+		stamps[path] = statFile(path)
+	}
+	return stamps
+}
+
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// changedFile возвращает файл, изменившийся после последней перезагрузки
+func (m *configManager) changedFile() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for path, stamp := range m.stamps {
+		if statFile(path) != stamp {
+			return path
+		}
+	}
+	return ""
+}
+
+// logConfigChanges выводит измененные настройки. Ключи и пароли не
+// выводятся, длинные значения сокращаются.
+func logConfigChanges(reason string, previous, current *config.Config) {
+	_, before := previous.EnvValues()
+	keys, after := current.EnvValues()
+
+	// Ключи удаленных провайдеров и сайтов есть только в старой конфигурации
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		seen[key] = true
+	}
+	var removed []string
+	for key := range before {
+		if !seen[key] {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	keys = append(keys, removed...)
+
+	var changes []string
+	for _, key := range keys {
+		if before[key] == after[key] {
+			continue
+		}
+		change := fmt.Sprintf("%s: %s -> %s", key, logValue(key, before[key]), logValue(key, after[key]))
+		for _, restart := range restartKeys {
+			if key == restart {
+				change += " (применится после перезапуска)"
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	if len(changes) == 0 {
+		log.Printf("Конфигурация перезагружена (%s), настройки не изменились", reason)
+		return
+	}
+	log.Printf("Конфигурация перезагружена (%s):", reason)
+	for _, change := range changes {
+		log.Printf("  %s", change)
+	}
+}
+
+func logValue(key, value string) string {
+	switch {
+	case value == "":
+		return "(не задано)"
+	case strings.HasSuffix(key, "_API_KEY") || strings.HasSuffix(key, "_TOKEN") || strings.HasSuffix(key, "_HASH"):
+		return maskKey(value)
+	}
+	value = strings.Join(strings.Fields(value), " ")
+	if utf8.RuneCountInString(value) > 60 {
+		value = string([]rune(value)[:60]) + "…"
+	}
+	return fmt.Sprintf("%q", value)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"ai-bot/ai"
+	"ai-bot/config"
+	"ai-bot/ratelimit"
+)
+
+// site настройки сайта и ограничения, построенные из них
+type site struct {
+	config.SiteConfig
+	origins         originPolicy
+	perIP           *ratelimit.Limiter
+	perConversation *ratelimit.Limiter
+}
+
+func newSite(cfg config.SiteConfig, origins []string) *site {
+	return &site{
+		SiteConfig:      cfg,
+		origins:         newOriginPolicy(origins),
+		perIP:           ratelimit.NewLimiter(cfg.RateLimitIP, cfg.RateLimitIPBurst),
+		perConversation: ratelimit.NewLimiter(cfg.RateLimitConversation, cfg.RateLimitConversationBurst),
+	}
+}
+
+// siteRegistry сайты по публичному ключу. Запросы без ключа относятся
+// к сайту по умолчанию с общими настройками.
+type siteRegistry struct {
+	byKey    map[string]*site
+	fallback *site
+}
+
+// newSiteRegistry проверяет настройки сайтов: ключи уникальны, а
+// провайдеры сайтов есть среди настроенных провайдеров клиента
+func newSiteRegistry(cfg *config.Config, client *ai.Client) (*siteRegistry, error) {
+	registry := &siteRegistry{
+		byKey: make(map[string]*site, len(cfg.Sites)),
+		fallback: newSite(config.SiteConfig{
+			PromptPolicy:               cfg.PromptPolicy,
+			PromptPreset:               cfg.PromptPreset,
+			RateLimitIP:                cfg.RateLimitIP,
+			RateLimitIPBurst:           cfg.RateLimitIPBurst,
+			RateLimitConversation:      cfg.RateLimitConversation,
+			RateLimitConversationBurst: cfg.RateLimitConversationBurst,
+		}, cfg.AllowedOrigins),
+	}
+
+	for _, siteCfg := range cfg.Sites {
+		if siteCfg.Key == "" {
+			return nil, fmt.Errorf("site %q: key is required", siteCfg.Name)
+		}
+		if _, exists := registry.byKey[siteCfg.Key]; exists {
+			return nil, fmt.Errorf("site %q: duplicate key %q", siteCfg.Name, siteCfg.Key)
+		}
+		if siteCfg.Provider != "" {
+			if _, ok := client.WithProvider(siteCfg.Provider); !ok {
+				return nil, fmt.Errorf("site %q: provider %q is not configured", siteCfg.Name, siteCfg.Provider)
+			}
+		}
+		if siteCfg.Hedge.Provider != "" {
+			if _, ok := client.WithProvider(siteCfg.Hedge.Provider); !ok {
+				return nil, fmt.Errorf("site %q: hedge provider %q is not configured", siteCfg.Name, siteCfg.Hedge.Provider)
+			}
+		}
+
+		// Без своего списка сайт наследует общий ALLOWED_ORIGINS
+		origins := siteCfg.AllowedOrigins
+		if len(origins) == 0 {
+			origins = cfg.AllowedOrigins
+		}
+		registry.byKey[siteCfg.Key] = newSite(siteCfg, origins)
+	}
+
+	return registry, nil
+}
+
+// inherit переносит счетчики ограничений запросов из прежнего реестра в
+// сайты с теми же ключом и лимитами, чтобы перезагрузка конфигурации не
+// сбрасывала ограничения
+func (s *siteRegistry) inherit(previous *siteRegistry) {
+	pairs := map[*site]*site{s.fallback: previous.fallback}
+	for key, found := range s.byKey {
+		if old, ok := previous.byKey[key]; ok {
+			pairs[found] = old
+		}
+	}
+	for found, old := range pairs {
+		if found.RateLimitIP == old.RateLimitIP && found.RateLimitIPBurst == old.RateLimitIPBurst {
+			found.perIP = old.perIP
+		}
+		if found.RateLimitConversation == old.RateLimitConversation && found.RateLimitConversationBurst == old.RateLimitConversationBurst {
+			found.perConversation = old.perConversation
+		}
+	}
+}
+
+// Lookup возвращает сайт по ключу; пустой ключ - сайт по умолчанию
+func (s *siteRegistry) Lookup(key string) (*site, bool) {
+	if key == "" {
+		return s.fallback, true
+	}
+	found, ok := s.byKey[key]
+	return found, ok
+}
+
+// Wrap определяет сайт по параметру site_key, проверяет Origin по списку
+// сайта и передает сайт обработчику через контекст запроса. Ключ
+// передается в URL, чтобы его видели и preflight запросы OPTIONS.
+func (s *siteRegistry) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		found, ok := s.Lookup(r.URL.Query().Get("site_key"))
+		if !ok {
+			writeChatError(w, responseRequestID(w), chatError{Code: codeUnknownSite, Message: "Неизвестный ключ сайта.", status: http.StatusForbidden})
+			return
+		}
+		if !found.origins.Check(w, r) {
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), siteContextKey{}, found)))
+	}
+}
+
+type siteContextKey struct{}
+
+// siteFromRequest возвращает сайт, определенный siteRegistry.Wrap
+func siteFromRequest(r *http.Request) *site {
+	if found, ok := r.Context().Value(siteContextKey{}).(*site); ok {
+		return found
+	}
+	return &site{}
+}
+
+// chatClient возвращает клиента AI с провайдером и моделью сайта
+func (s *site) chatClient(client *ai.Client) *ai.Client {
+	if s.Provider != "" {
+		client, _ = client.WithProvider(s.Provider)
+	}
+	if s.Model != "" {
+		client = client.WithModel(s.Model)
+	}
+	return client
+}
+
+// hedgedClient возвращает клиента, который дублирует запрос по настройкам
+// hedge, или client без изменений, если второй запрос не задан или его
+// провайдера нет у клиента
+func hedgedClient(client *ai.Client, hedge config.Hedge) *ai.Client {
+	if !hedge.Enabled() {
+		return client
+	}
+	hedged, ok := client.WithHedge(ai.Hedge{
+		Provider: hedge.Provider,
+		Model:    hedge.Model,
+		Delay:    time.Duration(hedge.DelayMs) * time.Millisecond,
+	})
+	if !ok {
+		log.Printf("Второй запрос не отправлен: провайдер %q не настроен", hedge.Provider)
+	}
+	return hedged
+}
@@ -1,9 +1,23 @@
 // AI Bot JavaScript клиент
 class AIBot {
+    // Сообщения для кодов ошибок сервера (поле code ответа /api/chat)
+    static errorMessages = {
+        rate_limited: 'Превышен лимит запросов сервера или провайдера AI. Попробуйте через минуту.',
+        unknown_site: 'Неизвестный ключ сайта (site_key).',
+        origin_not_allowed: 'Этот Origin не входит в разрешенные (ALLOWED_ORIGINS).',
+        context_too_long: 'Сообщение не помещается в контекст модели вместе с системным промптом. Сократите его.',
+        content_filtered: 'Запрос отклонен фильтром содержимого провайдера. Попробуйте сформулировать его иначе.',
+        timeout: 'AI не ответил за отведенное время (TIMEOUT). Попробуйте еще раз.',
+        auth: 'Провайдер AI не принял ключ. Проверьте конфигурацию API ключей.',
+        no_provider: 'Нет доступных провайдеров AI. Попробуйте позже.',
+        invalid_request: 'Некорректный запрос. Проверьте текст сообщения.',
+    };
+
     constructor() {
         this.apiUrl = '/api/chat';
         this.statusUrl = '/api/status';
-        this.history = [];
+        // История разговора хранится на сервере, клиент передает только его id
+        this.conversationId = null;
         this.init();
     }
 
@@ -32,7 +46,7 @@ class AIBot {
             const status = await response.json();
             
             if (status.available) {
-                this.statusElement.textContent = `✓ Подключено (${status.provider})`;
+                this.statusElement.textContent = '✓ Подключено';
                 this.statusElement.style.color = '#4caf50';
             } else {
                 this.statusElement.textContent = `✗ Ошибка: ${status.error || 'Неизвестная ошибка'}`;
@@ -63,32 +77,27 @@ class AIBot {
                 },
                 body: JSON.stringify({
                     message: message,
-                    history: this.history,
+                    conversation_id: this.conversationId,
                 }),
             });
 
             if (!response.ok) {
-                throw new Error(`HTTP error! status: ${response.status}`);
+                // Сервер отвечает {code, message, request_id}
+                const failure = await response.json().catch(() => ({}));
+                const error = new Error(failure.message || `HTTP ${response.status}`);
+                error.code = failure.code;
+                error.requestId = failure.request_id;
+                throw error;
             }
 
             const data = await response.json();
-            
-            // Добавляем сообщение пользователя в историю
-            this.history.push({
-                role: 'user',
-                content: message,
-            });
-
-            // Добавляем ответ бота в историю и чат
-            this.history.push({
-                role: 'assistant',
-                content: data.response,
-            });
+            this.conversationId = data.conversation_id;
 
+            // Добавляем ответ бота в чат
             this.addMessage('bot', data.response);
         } catch (error) {
             console.error('Error:', error);
-            this.addMessage('bot', `Ошибка: ${error.message}. Проверьте конфигурацию API ключей.`);
+            this.addMessage('bot', AIBot.errorMessages[error.code] || `Ошибка: ${error.message}. Попробуйте еще раз.`);
         } finally {
             this.setLoading(false);
         }
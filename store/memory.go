@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore хранит разговоры в памяти процесса. Разговоры, не
+// обновлявшиеся дольше ttl, удаляются фоновой очисткой.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	conversations map[string]*Conversation
+	ttl           time.Duration
+	stop          chan struct{}
+	stopOnce      sync.Once
+}
+
+// NewMemoryStore создает хранилище в памяти. ttl <= 0 отключает удаление.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	s := &MemoryStore{
+		conversations: make(map[string]*Conversation),
+		ttl:           ttl,
+		stop:          make(chan struct{}),
+	}
+
+	if ttl > 0 {
+		interval := time.Minute
+		if ttl < interval {
+			interval = ttl
+		}
+		go s.evictLoop(interval)
+	}
+
+	return s
+}
+
+// Create сохраняет новый разговор вместе с сообщениями
+func (s *MemoryStore) Create(ctx context.Context, conv *Conversation) error {
+	now := time.Now()
+	conv = copyConversation(conv)
+	conv.CreatedAt = now
+	conv.UpdatedAt = now
+	for i := range conv.Messages {
+		if conv.Messages[i].CreatedAt.IsZero() {
+			conv.Messages[i].CreatedAt = now
+		}
+	}
+
+	s.mu.Lock()
+	s.conversations[conv.ID] = conv
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Get возвращает копию разговора или ErrNotFound
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	conv, ok := s.conversations[id]
+	if !ok || s.expired(conv, time.Now()) {
+		return nil, ErrNotFound
+	}
+	return copyConversation(conv), nil
+}
+
+// Append добавляет сообщения в конец разговора
+func (s *MemoryStore) Append(ctx context.Context, id string, messages ...Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, ok := s.conversations[id]
+	now := time.Now()
+	if !ok || s.expired(conv, now) {
+		return ErrNotFound
+	}
+
+	for _, msg := range messages {
+		if msg.CreatedAt.IsZero() {
+			msg.CreatedAt = now
+		}
+		conv.Messages = append(conv.Messages, msg)
+	}
+	conv.UpdatedAt = now
+
+	return nil
+}
+
+// SetSummary сохраняет краткое содержание первых count сообщений
+func (s *MemoryStore) SetSummary(ctx context.Context, id string, summary string, count int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, ok := s.conversations[id]
+	if !ok || s.expired(conv, time.Now()) {
+		return ErrNotFound
+	}
+
+	conv.Summary = summary
+	conv.SummarizedCount = count
+	return nil
+}
+
+// Recent возвращает не больше limit разговоров, начиная с последних обновленных
+func (s *MemoryStore) Recent(ctx context.Context, limit int) ([]ConversationInfo, error) {
+	s.mu.RLock()
+	now := time.Now()
+	infos := make([]ConversationInfo, 0, len(s.conversations))
+	for _, conv := range s.conversations {
+		if s.expired(conv, now) {
+			continue
+		}
+		info := ConversationInfo{
+			ID:        conv.ID,
+			Origin:    conv.Origin,
+			CreatedAt: conv.CreatedAt,
+			UpdatedAt: conv.UpdatedAt,
+			Messages:  len(conv.Messages),
+		}
+		for _, msg := range conv.Messages {
+			info.PromptTokens += msg.PromptTokens
+			info.CompletionTokens += msg.CompletionTokens
+			if info.Preview == "" && msg.Role == "user" {
+				info.Preview = preview(msg.Content)
+			}
+		}
+		infos = append(infos, info)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].UpdatedAt.After(infos[j].UpdatedAt)
+	})
+	if len(infos) > limit {
+		infos = infos[:limit]
+	}
+	return infos, nil
+}
+
+// Close останавливает фоновую очистку
+func (s *MemoryStore) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	return nil
+}
+
+// expired проверяет, истек ли срок жизни разговора
+func (s *MemoryStore) expired(conv *Conversation, now time.Time) bool {
+	return s.ttl > 0 && now.Sub(conv.UpdatedAt) > s.ttl
+}
+
+// evictLoop периодически удаляет истекшие разговоры
+func (s *MemoryStore) evictLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for id, conv := range s.conversations {
+				if s.expired(conv, now) {
+					delete(s.conversations, id)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// copyConversation возвращает копию разговора, чтобы вызывающий код
+// не мог изменить данные хранилища в обход блокировки
+func copyConversation(conv *Conversation) *Conversation {
+	c := *conv
+	c.Messages = make([]Message, len(conv.Messages))
+	copy(c.Messages, conv.Messages)
+	return &c
+}
@@ -0,0 +1,278 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	// Драйвер SQLite на чистом Go, сборка не требует cgo
+	_ "modernc.org/sqlite"
+)
+
+// migrations схема базы данных. Миграции применяются по порядку при
+// открытии хранилища; уже примененные версии записаны в schema_migrations.
+// Новые миграции добавляются только в конец списка.
+var migrations = []string{
+	// 1: разговоры и сообщения
+	`CREATE TABLE conversations (
+		id         TEXT PRIMARY KEY,
+		origin     TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE TABLE messages (
+		id                INTEGER PRIMARY KEY AUTOINCREMENT,
+		conversation_id   TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+		role              TEXT NOT NULL,
+		content           TEXT NOT NULL,
+		model             TEXT NOT NULL DEFAULT '',
+		prompt_tokens     INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		created_at        INTEGER NOT NULL
+	);
+	CREATE INDEX messages_conversation_idx ON messages(conversation_id, id);
+	CREATE INDEX conversations_updated_idx ON conversations(updated_at);`,
+	// 2: кэш краткого содержания для длинных разговоров
+	`ALTER TABLE conversations ADD COLUMN summary TEXT NOT NULL DEFAULT '';
+	ALTER TABLE conversations ADD COLUMN summarized_count INTEGER NOT NULL DEFAULT 0;`,
+	// 3: сайт, в котором начат разговор
+	`ALTER TABLE conversations ADD COLUMN site_key TEXT NOT NULL DEFAULT '';`,
+}
+
+// SQLiteStore хранит разговоры в SQLite. Разговоры не удаляются по ttl,
+// чтобы оставаться доступными для аудита, но истекшие нельзя продолжить.
+type SQLiteStore struct {
+	db  *sql.DB
+	ttl time.Duration
+}
+
+// NewSQLiteStore открывает (или создает) базу и применяет миграции
+func NewSQLiteStore(path string, ttl time.Duration) (*SQLiteStore, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// SQLite допускает одного писателя, общее соединение исключает SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteStore{db: db, ttl: ttl}
+	if err := s.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// migrate применяет недостающие миграции
+func (s *SQLiteStore) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var current int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version, time.Now().UnixMilli()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d failed: %w", version, err)
+		}
+	}
+
+	return nil
+}
+
+// Create сохраняет новый разговор вместе с сообщениями
+func (s *SQLiteStore) Create(ctx context.Context, conv *Conversation) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO conversations (id, origin, site_key, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`,
+		conv.ID, conv.Origin, conv.SiteKey, now.UnixMilli(), now.UnixMilli(),
+	); err != nil {
+		return fmt.Errorf("failed to create conversation: %w", err)
+	}
+	if err := insertMessages(ctx, tx, conv.ID, now, conv.Messages); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get возвращает разговор с сообщениями или ErrNotFound
+func (s *SQLiteStore) Get(ctx context.Context, id string) (*Conversation, error) {
+	conv := &Conversation{ID: id}
+	var createdAt, updatedAt int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT origin, site_key, created_at, updated_at, summary, summarized_count FROM conversations WHERE id = ?`, id,
+	).Scan(&conv.Origin, &conv.SiteKey, &createdAt, &updatedAt, &conv.Summary, &conv.SummarizedCount)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load conversation: %w", err)
+	}
+	conv.CreatedAt = time.UnixMilli(createdAt)
+	conv.UpdatedAt = time.UnixMilli(updatedAt)
+
+	if s.expired(conv.UpdatedAt, time.Now()) {
+		return nil, ErrNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT role, content, model, prompt_tokens, completion_tokens, created_at
+		 FROM messages WHERE conversation_id = ? ORDER BY id`, id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg Message
+		var msgCreatedAt int64
+		if err := rows.Scan(&msg.Role, &msg.Content, &msg.Model, &msg.PromptTokens, &msg.CompletionTokens, &msgCreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to load messages: %w", err)
+		}
+		msg.CreatedAt = time.UnixMilli(msgCreatedAt)
+		conv.Messages = append(conv.Messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	return conv, nil
+}
+
+// Append добавляет сообщения в конец разговора
+func (s *SQLiteStore) Append(ctx context.Context, id string, messages ...Message) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var updatedAt int64
+	err = tx.QueryRowContext(ctx, `SELECT updated_at FROM conversations WHERE id = ?`, id).Scan(&updatedAt)
+	if err == sql.ErrNoRows || (err == nil && s.expired(time.UnixMilli(updatedAt), now)) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load conversation: %w", err)
+	}
+
+	if err := insertMessages(ctx, tx, id, now, messages); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE conversations SET updated_at = ? WHERE id = ?`, now.UnixMilli(), id); err != nil {
+		return fmt.Errorf("failed to update conversation: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// insertMessages добавляет сообщения разговора id в транзакции tx.
+// Сообщениям без времени создания ставится now.
+func insertMessages(ctx context.Context, tx *sql.Tx, id string, now time.Time, messages []Message) error {
+	for _, msg := range messages {
+		createdAt := msg.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = now
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO messages (conversation_id, role, content, model, prompt_tokens, completion_tokens, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, msg.Role, msg.Content, msg.Model, msg.PromptTokens, msg.CompletionTokens, createdAt.UnixMilli(),
+		); err != nil {
+			return fmt.Errorf("failed to save message: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetSummary сохраняет краткое содержание первых count сообщений
+func (s *SQLiteStore) SetSummary(ctx context.Context, id string, summary string, count int) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE conversations SET summary = ?, summarized_count = ? WHERE id = ?`,
+		summary, count, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save summary: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Recent возвращает не больше limit разговоров, начиная с последних
+// обновленных. Истекшие разговоры тоже попадают в список.
+func (s *SQLiteStore) Recent(ctx context.Context, limit int) ([]ConversationInfo, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT c.id, c.origin, c.created_at, c.updated_at,
+		        COUNT(m.id), COALESCE(SUM(m.prompt_tokens), 0), COALESCE(SUM(m.completion_tokens), 0),
+		        COALESCE((SELECT content FROM messages WHERE conversation_id = c.id AND role = 'user' ORDER BY id LIMIT 1), '')
+		 FROM conversations c LEFT JOIN messages m ON m.conversation_id = c.id
+		 GROUP BY c.id ORDER BY c.updated_at DESC LIMIT ?`, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var infos []ConversationInfo
+	for rows.Next() {
+		var info ConversationInfo
+		var createdAt, updatedAt int64
+		var first string
+		if err := rows.Scan(&info.ID, &info.Origin, &createdAt, &updatedAt,
+			&info.Messages, &info.PromptTokens, &info.CompletionTokens, &first); err != nil {
+			return nil, fmt.Errorf("failed to list conversations: %w", err)
+		}
+		info.CreatedAt = time.UnixMilli(createdAt)
+		info.UpdatedAt = time.UnixMilli(updatedAt)
+		info.Preview = preview(first)
+		infos = append(infos, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	return infos, nil
+}
+
+// Close закрывает базу данных
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// expired проверяет, истек ли срок, в течение которого разговор можно продолжить
+func (s *SQLiteStore) expired(updatedAt, now time.Time) bool {
+	return s.ttl > 0 && now.Sub(updatedAt) > s.ttl
+}
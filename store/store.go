@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrNotFound возвращается, если разговор не найден или истек
+var ErrNotFound = errors.New("conversation not found")
+
+// Message сообщение разговора
+type Message struct {
+	Role             string
+	Content          string
+	Model            string // Модель, сгенерировавшая ответ (для сообщений assistant)
+	PromptTokens     int
+	CompletionTokens int
+	CreatedAt        time.Time
+}
+
+// Conversation разговор с историей сообщений
+type Conversation struct {
+	ID        string
+	Origin    string // Origin сайта, с которого начат разговор
+	SiteKey   string // Ключ сайта (site_key), в котором начат разговор; пусто - без ключа
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Messages  []Message
+
+	// Summary краткое содержание первых SummarizedCount сообщений
+	Summary         string
+	SummarizedCount int
+}
+
+// ConversationInfo краткие сведения о разговоре для списка разговоров
+type ConversationInfo struct {
+	ID               string
+	Origin           string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Messages         int
+	PromptTokens     int
+	CompletionTokens int
+	Preview          string // Начало первого сообщения пользователя
+}
+
+// previewLength длина ConversationInfo.Preview в символах
+const previewLength = 100
+
+// preview возвращает начало сообщения для ConversationInfo.Preview
+func preview(content string) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if runes := []rune(content); len(runes) > previewLength {
+		return string(runes[:previewLength]) + "…"
+	}
+	return content
+}
+
+// SessionStore хранилище разговоров на стороне сервера
+type SessionStore interface {
+	// Create сохраняет новый разговор conv вместе с его сообщениями. ID
+	// задается заранее (NewID): пустые разговоры не сохраняются, а ID
+	// нужен клиенту до того, как появится первый ответ.
+	Create(ctx context.Context, conv *Conversation) error
+	// Get возвращает копию разговора или ErrNotFound
+	Get(ctx context.Context, id string) (*Conversation, error)
+	// Append добавляет сообщения в конец разговора
+	Append(ctx context.Context, id string, messages ...Message) error
+	// SetSummary сохраняет краткое содержание первых count сообщений
+	SetSummary(ctx context.Context, id string, summary string, count int) error
+	// Recent возвращает не больше limit разговоров, начиная с последних обновленных
+	Recent(ctx context.Context, limit int) ([]ConversationInfo, error)
+	// Close освобождает ресурсы хранилища
+	Close() error
+}
+
+// NewID генерирует случайный идентификатор разговора
+func NewID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,87 @@
+package usage
+
+import "time"
+
+// Limits лимиты расхода за период. Нулевое значение - без ограничения.
+type Limits struct {
+	Tokens int     // Всего токенов (запрос и ответ)
+	Cost   float64 // Стоимость в USD по ценам из списка моделей
+}
+
+// Budget дневные и месячные лимиты расхода
+type Budget struct {
+	Daily   Limits
+	Monthly Limits
+	// SoftPercent доля лимита в процентах, после которой включается
+	// экономный режим (например, более дешевая модель)
+	SoftPercent int
+}
+
+// Level состояние бюджета
+type Level int
+
+const (
+	// LevelOK лимиты не достигнуты
+	LevelOK Level = iota
+	// LevelSoft достигнут мягкий лимит: SoftPercent от любого из лимитов
+	LevelSoft
+	// LevelExceeded исчерпан хотя бы один лимит
+	LevelExceeded
+)
+
+// String возвращает название состояния для логов и админки
+func (l Level) String() string {
+	switch l {
+	case LevelSoft:
+		return "soft"
+	case LevelExceeded:
+		return "exceeded"
+	}
+	return "ok"
+}
+
+// Enabled проверяет, задан ли хотя бы один лимит
+func (b Budget) Enabled() bool {
+	return b.Daily != Limits{} || b.Monthly != Limits{}
+}
+
+// Check возвращает состояние бюджета с учетом расхода за текущие день и месяц
+func (t *Tracker) Check(budget Budget) Level {
+	if !budget.Enabled() {
+		return LevelOK
+	}
+
+	now := time.Now()
+	return maxLevel(
+		budget.level(t.Day(now), budget.Daily),
+		budget.level(t.Month(now), budget.Monthly),
+	)
+}
+
+// level сравнивает расход за период с лимитами периода
+func (b Budget) level(totals Totals, limits Limits) Level {
+	used := 0.0
+	if limits.Tokens > 0 {
+		used = float64(totals.TotalTokens) / float64(limits.Tokens)
+	}
+	if limits.Cost > 0 {
+		if cost := totals.Cost / limits.Cost; cost > used {
+			used = cost
+		}
+	}
+
+	switch {
+	case used >= 1:
+		return LevelExceeded
+	case b.SoftPercent > 0 && used*100 >= float64(b.SoftPercent):
+		return LevelSoft
+	}
+	return LevelOK
+}
+
+func maxLevel(a, b Level) Level {
+	if a > b {
+		return a
+	}
+	return b
+}
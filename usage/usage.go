@@ -0,0 +1,200 @@
+package usage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-bot/ai"
+)
+
+const (
+	// dayFormat формат ключа дня (локальное время сервера)
+	dayFormat = "2006-01-02"
+	// monthFormat префикс ключей дней одного месяца
+	monthFormat = "2006-01"
+)
+
+// keepDays сколько дней хранятся итоги; должно покрывать текущий месяц
+const keepDays = 62
+
+// Totals суммарный расход за период
+type Totals struct {
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// add добавляет расход одного запроса
+func (t *Totals) add(result *ai.ChatResult) {
+	t.Requests++
+	t.PromptTokens += result.Usage.PromptTokens
+	t.CompletionTokens += result.Usage.CompletionTokens
+	t.TotalTokens += result.Usage.TotalTokens
+	t.Cost += result.Cost
+}
+
+// merge добавляет итоги другого периода
+func (t *Totals) merge(other *Totals) {
+	t.Requests += other.Requests
+	t.PromptTokens += other.PromptTokens
+	t.CompletionTokens += other.CompletionTokens
+	t.TotalTokens += other.TotalTokens
+	t.Cost += other.Cost
+}
+
+// DayTotals итоги за один день
+type DayTotals struct {
+	Day string `json:"day"`
+	Totals
+}
+
+// Tracker считает расход токенов и стоимость по дням. Если задан путь к
+// файлу, итоги сохраняются в него после каждого запроса и переживают
+// перезапуск, чтобы лимиты бюджета нельзя было сбросить рестартом.
+type Tracker struct {
+	mu   sync.Mutex
+	days map[string]*Totals
+	path string
+}
+
+// NewTracker создает счетчик и загружает сохраненные итоги из path.
+// Пустой path - итоги хранятся только в памяти.
+func NewTracker(path string) (*Tracker, error) {
+	t := &Tracker{
+		days: make(map[string]*Totals),
+		path: path,
+	}
+	if path == "" {
+		return t, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage file: %w", err)
+	}
+	if err := json.Unmarshal(data, &t.days); err != nil {
+		return nil, fmt.Errorf("failed to parse usage file %s: %w", path, err)
+	}
+	t.prune(time.Now())
+
+	return t, nil
+}
+
+// Record учитывает результат запроса к AI. Подходит для ai.Client.SetUsageHook.
+func (t *Tracker) Record(result *ai.ChatResult) {
+	now := time.Now()
+	day := now.Format(dayFormat)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	totals, ok := t.days[day]
+	if !ok {
+		totals = &Totals{}
+		t.days[day] = totals
+		t.prune(now)
+	}
+	totals.add(result)
+
+	if err := t.save(); err != nil {
+		log.Printf("Ошибка сохранения статистики расхода: %v", err)
+	}
+}
+
+// Today возвращает итоги за текущий день
+func (t *Tracker) Today() Totals {
+	return t.Day(time.Now())
+}
+
+// Day возвращает итоги за день, в который попадает момент day
+func (t *Tracker) Day(day time.Time) Totals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if totals, ok := t.days[day.Format(dayFormat)]; ok {
+		return *totals
+	}
+	return Totals{}
+}
+
+// Month возвращает итоги за месяц, в который попадает момент month
+func (t *Tracker) Month(month time.Time) Totals {
+	prefix := month.Format(monthFormat)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var totals Totals
+	for day, dayTotals := range t.days {
+		if strings.HasPrefix(day, prefix) {
+			totals.merge(dayTotals)
+		}
+	}
+	return totals
+}
+
+// Days возвращает итоги по дням, от новых к старым
+func (t *Tracker) Days() []DayTotals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	days := make([]DayTotals, 0, len(t.days))
+	for day, totals := range t.days {
+		days = append(days, DayTotals{Day: day, Totals: *totals})
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Day > days[j].Day
+	})
+	return days
+}
+
+// prune удаляет итоги старше keepDays
+func (t *Tracker) prune(now time.Time) {
+	cutoff := now.AddDate(0, 0, -keepDays).Format(dayFormat)
+	for day := range t.days {
+		if day < cutoff {
+			delete(t.days, day)
+		}
+	}
+}
+
+// save атомарно записывает итоги в файл: сначала во временный, затем
+// переименовывает, чтобы сбой при записи не испортил сохраненные данные
+func (t *Tracker) save() error {
+	if t.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(t.days, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), filepath.Base(t.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), t.path)
+}